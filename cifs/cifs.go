@@ -4,15 +4,62 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"os"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 // ClientStats describes our CIFS statistics file.
 type ClientStats struct {
 	Header Header
 	Blocks []*Block
+	// Warnings holds every line the parser did not recognize.
+	// The kernel adds new lines from time to time, so the lenient parser does not fail on them.
+	Warnings []LineError
+	// SkippedBlocks is the number of blocks we skipped, because we could not parse their header.
+	SkippedBlocks int
+	// Stats2 is set if the kernel was built with CONFIG_CIFS_STATS2 and prints allocations and timings.
+	Stats2 bool
+	// Timings holds the per command timings of every connection, if Stats2 is set.
+	Timings []*ServerTimings
+	// InFlight holds the maximum number of requests in flight of every connection.
+	InFlight []*ServerInFlight
+}
+
+// ServerInFlight stores the maximum number of requests in flight of a single connection.
+// The kernel prints "Max requests in flight: N" in front of the timings and blocks of every
+// connection, without an empty line, so it directly follows the last block of the previous one.
+type ServerInFlight struct {
+	// Server is the server of the first block following the line, it is empty if the
+	// connection has no blocks.
+	Server      string
+	MaxRequests uint64
+}
+
+// Dialect describes which block layout the kernel printed for a share.
+// SMB2 and SMB3 shares share the same layout, so we can't tell them apart here.
+type Dialect int
+
+const (
+	// DialectUnknown is used for blocks without any dialect specific lines.
+	DialectUnknown Dialect = iota
+	// DialectSMB1 blocks print "Oplocks breaks", "T2 Renames" and friends.
+	DialectSMB1
+	// DialectSMB2 blocks print "<Command>: N sent M failed" lines.
+	DialectSMB2
+)
+
+// String returns the dialect name as used in labels.
+func (d Dialect) String() string {
+	switch d {
+	case DialectSMB1:
+		return "smb1"
+	case DialectSMB2:
+		return "smb2"
+	default:
+		return "unknown"
+	}
 }
 
 // Block stores each block with server, share and all metrics.
@@ -20,7 +67,29 @@ type ClientStats struct {
 type Block struct {
 	Server  string
 	Share   string
-	Metrics []uint64
+	Dialect Dialect
+	// Disconnected is set when the kernel marked the share with DISCONNECTED.
+	Disconnected bool
+	// SMBs is the number of SMBs sent for this share.
+	SMBs uint64
+	// OplockBreaks is "Oplocks breaks" for SMB1 and the sent OplockBreaks for SMB2.
+	OplockBreaks uint64
+	BytesRead    uint64
+	BytesWritten uint64
+	// HasBytes is set if the kernel printed the bytes read and written.
+	// SMB1 blocks always have them, SMB2 blocks only on newer kernels.
+	HasBytes bool
+	// Counters stores single values by their name in the Stats file, for example "Posix Opens".
+	Counters map[string]uint64
+	// Commands stores sent and failed requests by their name in the Stats file, for example "Creates".
+	Commands map[string]Command
+}
+
+// Command stores the sent and failed requests of a single SMB2 command.
+// Newer kernels print "total" instead of "sent", we store both as Sent.
+type Command struct {
+	Sent   uint64
+	Failed uint64
 }
 
 // Header stores all header information from the CIFS header.
@@ -38,116 +107,327 @@ type Header struct {
 	ShareReconnects uint64
 	MaxOp           uint64
 	AtOnce          uint64
+	// LargeAllocations and SmallAllocations are only printed with CONFIG_CIFS_STATS2.
+	LargeAllocations uint64
+	SmallAllocations uint64
 }
 
-// This is our multiline regex for the SMB blocks.
-// We can possibly get rid of the named regex groups.
-var re = regexp.MustCompile(`(?m)(?:(?:(?P<SMBID>\d+)\) \\\\(?P<Server>[A-Za-z1-9-.]+)(?P<Share>.+)\nSMBs:\s+(?P<SMB>\d+) Oplocks breaks:\s+(?P<OpLocks>\d+)\nReads:\s+(?P<Reads>\d+) Bytes:\s+(?P<ReadsBytes>\d+)\nWrites:\s+(?P<Writes>\d+) Bytes:\s+(?P<WritesBytes>\d+)\nFlushes:\s+(?P<Flushes>\d+)\nLocks:\s+(?P<Locks>\d+) HardLinks:\s+(?P<Hardlinks>\d+) Symlinks:\s+(?P<Symlinks>\d+)\nOpens:\s+(?P<Opens>\d+) Closes:\s+(?P<Closes>\d+) Deletes:\s+(?P<Deletes>\d+)\nPosix Opens:\s+(?P<PosixOpens>\d+) Posix Mkdirs:\s+(?P<PosixMkdirs>\d+)\nMkdirs:\s+(?P<Mkdirs>\d+) Rmdirs:\s+(?P<Rmdirs>\d+)\nRenames:\s+(?P<Renames>\d+) T2 Renames\s+(?P<T2Renames>\d+)\nFindFirst:\s+(?P<FindFirst>\d+) FNext\s+(?P<FNext>\d+) FClose\s+(?P<FClose>\d+)|(?P<SMB3ID>\d+)\) \\\\(?P<SMB3Server>[A-Za-z1-9-.]+)(?P<SMB3Share>.+)\nSMBs:\s+(?P<SMB3>\d+)\nNegotiates:\s+(?P<NegotiatesSent>\d+) sent\s+(?P<NegotiatesFailed>\d+) failed\nSessionSetups:\s+(?P<SessionSetupsSent>\d+) sent\s+(?P<SessionSetupsFailed>\d+) failed\nLogoffs:\s+(?P<LogoffsSent>\d+) sent\s+(?P<LogoffsFailed>\d+) failed\nTreeConnects:\s+(?P<TreeConnectsSent>\d+) sent\s+(?P<TreeConnectsFailed>\d+) failed\nTreeDisconnects:\s+(?P<TreeDisconnectsSent>\d+) sent\s+(?P<TreeDisconnectsFailed>\d+) failed\nCreates:\s+(?P<CreatesSent>\d+) sent\s+(?P<CreatesFailed>\d+) failed\nCloses:\s+(?P<ClosesSent>\d+) sent\s+(?P<ClosesFailed>\d+) failed\nFlushes:\s+(?P<FlushesSent>\d+) sent\s+(?P<FlushesFailed>\d+) failed\nReads:\s+(?P<ReadsSent>\d+) sent\s+(?P<ReadsFailed>\d+) failed\nWrites:\s+(?P<WritesSent>\d+) sent\s+(?P<WritesFailed>\d+) failed\nLocks:\s+(?P<LocksSent>\d+) sent\s+(?P<LocksFailed>\d+) failed\nIOCTLs:\s+(?P<IOCTLsSent>\d+) sent\s+(?P<IOCTLsFailed>\d+) failed\nCancels:\s+(?P<CancelsSent>\d+) sent\s+(?P<CancelsFailed>\d+) failed\nEchos:\s+(?P<EchosSent>\d+) sent\s+(?P<EchosFailed>\d+) failed\nQueryDirectories:\s+(?P<QueryDirectoriesSent>\d+) sent\s+(?P<QueryDirectoriesFailed>\d+) failed\nChangeNotifies:\s+(?P<ChangeNotifiesSent>\d+) sent\s+(?P<ChangeNotifiesFailed>\d+) failed\nQueryInfos:\s+(?P<QueryInfosSent>\d+) sent\s+(?P<QueryInfosFailed>\d+) failed\nSetInfos:\s+(?P<SetInfosSent>\d+) sent\s+(?P<SetInfosFailed>\d+) failed\nOplockBreaks:\s+(?P<OpLockBreaksSent>\d+) sent\s+(?P<OpLockBreaksFailed>\d+) failed)+)`)
+// counter is a single value parsed from a block line.
+// Lines like "Creates: 0 sent 2 failed" carry a second value for failed requests.
+type counter struct {
+	Name      string
+	Value     uint64
+	Failed    uint64
+	HasFailed bool
+}
 
-// NewClientStats opens the cifs stats file and returns our parsed CIFS client statistics.
-func NewClientStats() (*ClientStats, error) {
-	f, err := os.Open("/proc/fs/cifs/Stats")
+// blockHeader matches the first line of every SMB block, for example:
+// 1) \\server1\share1
+// The kernel appends a tab and DISCONNECTED if the share needs a reconnect.
+var blockHeader = regexp.MustCompile(`^\d+\) (\\\\.+)$`)
+
+// anyBlockHeader matches everything that looks like a block header, even without a valid UNC path.
+var anyBlockHeader = regexp.MustCompile(`^\d+\) `)
+
+// maxInFlightPrefix starts the line with the maximum requests in flight of a connection.
+const maxInFlightPrefix = "Max requests in flight:"
+
+// parseMaxInFlight parses a line like "Max requests in flight: 2".
+func parseMaxInFlight(line string) (*ServerInFlight, string) {
+	v, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, maxInFlightPrefix)), 10, 64)
 	if err != nil {
-		return nil, err
+		return nil, ReasonInvalidValue
 	}
-	defer f.Close()
-	return ParseClientStats(f)
+	return &ServerInFlight{MaxRequests: v}, ""
+}
+
+// parser states for ParseClientStats
+const (
+	stateHeader = iota
+	stateBlock
+	stateBetween
+	stateSkippedBlock
+	stateTimings
+)
+
+// NewClientStats opens the cifs stats file and returns our parsed CIFS client statistics.
+// It always reads /proc/fs/cifs/Stats, use FS.ClientStats for a different proc mount point.
+func NewClientStats() (*ClientStats, error) {
+	return FS{proc: DefaultProcMountPoint}.ClientStats()
 }
 
 // parseHeader uses fmt.Sscanf() for matching all information in the header.
-// I hope this approach is faster than using a multiline regex for the header.
-func (stats *ClientStats) parseHeader(line string) {
-	if _, err := fmt.Sscanf(line, "CIFS Session: %d", &stats.Header.CIFSSession); err == nil {
-		return
+// It returns the reason if the line is not a valid header line.
+func (stats *ClientStats) parseHeader(line string) string {
+	if line == "Resources in use" {
+		return ""
 	}
-	if _, err := fmt.Sscanf(line, "Share (unique mount targets): %d", &stats.Header.Targets); err == nil {
-		return
+	h := &stats.Header
+	headerLines := []struct {
+		format string
+		args   []interface{}
+	}{
+		{"CIFS Session: %d", []interface{}{&h.CIFSSession}},
+		{"Share (unique mount targets): %d", []interface{}{&h.Targets}},
+		{"SMB Request/Response Buffer: %d Pool size: %d", []interface{}{&h.SMBReq, &h.SMBBuf}},
+		{"SMB Small Req/Resp Buffer: %d Pool size: %d", []interface{}{&h.SMBSmallReq, &h.SMBSmallBuf}},
+		{"Total Large %d Small %d Allocations", []interface{}{&h.LargeAllocations, &h.SmallAllocations}},
+		{"Operations (MIDs): %d", []interface{}{&h.Op}},
+		{"%d session %d share reconnects", []interface{}{&h.Session, &h.ShareReconnects}},
+		{"Total vfs operations: %d maximum at one time: %d", []interface{}{&h.MaxOp, &h.AtOnce}},
 	}
-	if _, err := fmt.Sscanf(line, "SMB Request/Response Buffer: %d Pool size: %d", &stats.Header.SMBReq, &stats.Header.SMBBuf); err == nil {
-		return
+	for _, l := range headerLines {
+		if _, err := fmt.Sscanf(line, l.format, l.args...); err == nil {
+			stats.Stats2 = stats.Stats2 || strings.HasPrefix(l.format, "Total Large")
+			return ""
+		}
+		// The line is known, but the values are broken
+		if prefix := l.format[:strings.Index(l.format, "%")]; prefix != "" && strings.HasPrefix(line, prefix) {
+			return ReasonInvalidValue
+		}
+	}
+	if strings.HasSuffix(line, "share reconnects") {
+		return ReasonInvalidValue
 	}
-	if _, err := fmt.Sscanf(line, "SMB Small Req/Resp Buffer: %d Pool size: %d", &stats.Header.SMBSmallReq, &stats.Header.SMBSmallBuf); err == nil {
-		return
+	return ReasonUnknownLine
+}
+
+// parseBlockHeader creates a new block from a line like "1) \\server1\share1".
+// It returns nil if the line does not start a new block with a valid UNC path.
+func parseBlockHeader(line string) *Block {
+	match := blockHeader.FindStringSubmatch(line)
+	if match == nil {
+		return nil
 	}
-	if _, err := fmt.Sscanf(line, "Operations (MIDs): %d", &stats.Header.Op); err == nil {
-		return
+	block := &Block{
+		Counters: map[string]uint64{},
+		Commands: map[string]Command{},
 	}
-	if _, err := fmt.Sscanf(line, "%d session %d share reconnects", &stats.Header.Session, &stats.Header.ShareReconnects); err == nil {
-		return
+	unc := match[1]
+	if i := strings.Index(unc, "\t"); i >= 0 {
+		block.Disconnected = strings.Contains(unc[i:], "DISCONNECTED")
+		unc = unc[:i]
 	}
-	if _, err := fmt.Sscanf(line, "Total vfs operations: %d maximum at one time: %d", &stats.Header.MaxOp, &stats.Header.AtOnce); err == nil {
-		return
+	u, err := ParseUNC(strings.TrimSpace(unc))
+	if err != nil {
+		return nil
 	}
+	block.Server, block.Share = u.Server, u.Share
+	return block
 }
 
-// parseSMBBlocks uses a multiline regex for matching all SMB blocks.
-// SMB1/2 blocks start at match position 2
-// SMB3 blocks start at match position 27
-func (stats *ClientStats) parseSMBBlocks(file string) {
-	matches := re.FindAllStringSubmatch(file, -1)
-	for _, match := range matches {
-		// We need to match the right SMB block
-		// If match[1] == "" then we have a SMB3 version Block
-		if match[1] == "" {
-			block := &Block{
-				// These are hard offsets right now for the matched SMB3 block
-				Server:  match[27],
-				Share:   match[28],
-				Metrics: []uint64{},
-			}
-			// match[29] is where the metrics start for the matched SMB3 block
-			for i := 29; i < len(match); i++ {
-				m, err := strconv.ParseUint(match[i], 10, 64)
-				if err != nil {
-					break
-				}
-				block.Metrics = append(block.Metrics, m)
+// add stores the counters of a single block line in their named fields.
+func (b *Block) add(counters []counter) {
+	for i, c := range counters {
+		switch {
+		case c.HasFailed:
+			b.Dialect = DialectSMB2
+			b.Commands[c.Name] = Command{Sent: c.Value, Failed: c.Failed}
+			if c.Name == "OplockBreaks" {
+				b.OplockBreaks = c.Value
 			}
-			stats.Blocks = append(stats.Blocks, block)
-			// Here we go into a SMB1/2 version Block
-		} else {
-			block := &Block{
-				Server:  match[2],
-				Share:   match[3],
-				Metrics: []uint64{},
+		case c.Name == "SMBs":
+			b.SMBs = c.Value
+		case c.Name == "Oplocks breaks":
+			b.Dialect = DialectSMB1
+			b.OplockBreaks = c.Value
+		case c.Name == "Bytes read":
+			b.BytesRead, b.HasBytes = c.Value, true
+		case c.Name == "Bytes written":
+			b.BytesWritten, b.HasBytes = c.Value, true
+		// SMB1 prints the bytes right after the reads and writes: "Reads: 0 Bytes: 0"
+		case c.Name == "Bytes" && i > 0 && counters[i-1].Name == "Reads":
+			b.BytesRead, b.HasBytes = c.Value, true
+		case c.Name == "Bytes" && i > 0 && counters[i-1].Name == "Writes":
+			b.BytesWritten, b.HasBytes = c.Value, true
+		default:
+			b.Counters[c.Name] = c.Value
+		}
+	}
+}
+
+// CounterNames returns the names of all single value counters in a stable order.
+func (b *Block) CounterNames() []string {
+	names := make([]string, 0, len(b.Counters))
+	for name := range b.Counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CommandNames returns the names of all SMB2 commands in a stable order.
+func (b *Block) CommandNames() []string {
+	names := make([]string, 0, len(b.Commands))
+	for name := range b.Commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseCounters parses all "Name: N" and "Name: N sent M failed" pairs of a block line.
+// Names may consist of several words, for example "Posix Opens: 0" or "T2 Renames 0".
+// It returns the reason if the line does not consist of such pairs.
+func parseCounters(line string) ([]counter, string) {
+	// These are the only lines with words after the last value.
+	var local, remote uint64
+	// Newer kernels print when the statistics were cleared: "SMBs: 12 since 2023-05-01 10:00:00 UTC"
+	if i := strings.Index(line, " since "); i >= 0 && strings.HasPrefix(line, "SMBs:") {
+		line = line[:i]
+	}
+	if _, err := fmt.Sscanf(line, "Open files: %d total (local), %d open on server", &local, &remote); err == nil {
+		return []counter{{Name: "Open files", Value: local}, {Name: "Open files on server", Value: remote}}, ""
+	}
+	var counters []counter
+	var name []string
+	fields := strings.Fields(line)
+	for i := 0; i < len(fields); i++ {
+		value, err := strconv.ParseUint(fields[i], 10, 64)
+		if err != nil {
+			if isNumber(fields[i]) {
+				return nil, ReasonInvalidValue
 			}
-			// match[4] is where the metrics start for the matched SMB1/2 block
-			for i := 4; i < len(match); i++ {
-				m, err := strconv.ParseUint(match[i], 10, 64)
-				if err != nil {
-					break
-				}
-				block.Metrics = append(block.Metrics, m)
+			name = append(name, fields[i])
+			continue
+		}
+		if len(name) == 0 {
+			return nil, ReasonUnknownLine
+		}
+		c := counter{Name: strings.TrimSuffix(strings.Join(name, " "), ":"), Value: value}
+		// Newer kernels print "N total M failed" instead of "N sent M failed"
+		if i+3 < len(fields) && (fields[i+1] == "sent" || fields[i+1] == "total") && fields[i+3] == "failed" {
+			if failed, err := strconv.ParseUint(fields[i+2], 10, 64); err == nil {
+				c.Failed = failed
+				c.HasFailed = true
+				i += 3
 			}
-			stats.Blocks = append(stats.Blocks, block)
 		}
+		counters = append(counters, c)
+		name = nil
 	}
+	if len(name) > 0 || len(counters) == 0 {
+		return nil, ReasonUnknownLine
+	}
+	return counters, ""
 }
 
-// ParseClientstats scans the CIFS statistics file for the header and breaks.
-// Then it scans the rest of the file and calls parseSMBBlocks for the multiline regex for
-// matching all SMB blocks.
+// isNumber reports whether s looks like a number, even if it does not fit into an uint64.
+func isNumber(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseClientStats scans the CIFS statistics file line by line.
+// It starts in the header and switches to a new SMB block for every block header line.
+// Each block line is split into its counters, so we don't depend on a fixed block layout.
+// The parser is lenient: lines we can't parse are collected in ClientStats.Warnings.
 func ParseClientStats(r io.Reader) (*ClientStats, error) {
+	return parseClientStats(r, false)
+}
+
+// ParseClientStatsStrict works like ParseClientStats, but returns a *ParseError
+// together with the partially parsed statistics if any line could not be parsed.
+func ParseClientStatsStrict(r io.Reader) (*ClientStats, error) {
+	return parseClientStats(r, true)
+}
+
+func parseClientStats(r io.Reader, strict bool) (*ClientStats, error) {
 	stats := &ClientStats{}
 	scanner := bufio.NewScanner(r)
-	// parse Header
-	headerLen := 9
+	state := stateHeader
+	var block *Block
+	// timings and inFlight wait for the first block of their connection, which tells us the server
+	var timings *ServerTimings
+	var inFlight *ServerInFlight
+	n := 0
 	for scanner.Scan() {
-		if headerLen == 0 {
-			break
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			if state == stateBlock || state == stateSkippedBlock {
+				state = stateBetween
+			}
+			continue
+		}
+		if b := parseBlockHeader(line); b != nil {
+			block = b
+			stats.Blocks = append(stats.Blocks, block)
+			state = stateBlock
+			if timings != nil {
+				timings.Server = block.Server
+				timings = nil
+			}
+			if inFlight != nil {
+				inFlight.Server = block.Server
+				inFlight = nil
+			}
+			continue
+		}
+		if strings.HasPrefix(line, maxInFlightPrefix) {
+			// The line belongs to the next connection, so it ends the current block
+			var reason string
+			if inFlight, reason = parseMaxInFlight(line); reason == "" {
+				stats.InFlight = append(stats.InFlight, inFlight)
+			} else {
+				stats.Warnings = append(stats.Warnings, LineError{Line: n, Text: line, Reason: reason})
+			}
+			if state != stateHeader {
+				state = stateBetween
+			}
+			continue
+		}
+		if isTimingsHeader(line) {
+			var reason string
+			timings, reason = parseTimingsHeader(line)
+			stats.Timings = append(stats.Timings, timings)
+			stats.Stats2 = true
+			state = stateTimings
+			if reason != "" {
+				stats.Warnings = append(stats.Warnings, LineError{Line: n, Text: line, Reason: reason})
+			}
+			continue
+		}
+		if anyBlockHeader.MatchString(line) {
+			// We don't know where the values of this block belong to, so we skip all of them.
+			stats.SkippedBlocks++
+			stats.Warnings = append(stats.Warnings, LineError{Line: n, Text: line, Reason: ReasonInvalidBlockHeader})
+			state = stateSkippedBlock
+			continue
+		}
+		var reason string
+		switch state {
+		case stateHeader:
+			reason = stats.parseHeader(line)
+		case stateBlock:
+			var counters []counter
+			if counters, reason = parseCounters(line); reason == "" {
+				block.add(counters)
+			}
+		case stateSkippedBlock:
+			continue
+		case stateTimings:
+			reason = stats.Timings[len(stats.Timings)-1].parseLine(line)
+		default:
+			reason = ReasonOutsideBlock
+		}
+		if reason != "" {
+			stats.Warnings = append(stats.Warnings, LineError{Line: n, Text: line, Reason: reason})
 		}
-		line := scanner.Text()
-		stats.parseHeader(line)
-		headerLen--
 	}
-	// construct SMB block file
-	var file string
-	for scanner.Scan() {
-		line := scanner.Text()
-		// We need to add a newline here, otherwise we will end up with one line and our
-		// multiline regex will not match.
-		file += line + "\n"
+	if err := scanner.Err(); err != nil {
+		return stats, err
+	}
+	if strict && len(stats.Warnings) > 0 {
+		return stats, &ParseError{Errors: stats.Warnings}
 	}
-	stats.parseSMBBlocks(file)
 	return stats, nil
 }
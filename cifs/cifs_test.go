@@ -0,0 +1,255 @@
+package cifs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// openExample opens a file of the examples directory.
+func openExample(t *testing.T, name string) *os.File {
+	t.Helper()
+	f, err := os.Open(filepath.Join("..", "examples", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// blockSummary are the values of a block we compare in the tests.
+type blockSummary struct {
+	Server, Share string
+	Dialect       Dialect
+	Disconnected  bool
+	SMBs          uint64
+	BytesRead     uint64
+	BytesWritten  uint64
+	HasBytes      bool
+}
+
+func summarize(blocks []*Block) []blockSummary {
+	var res []blockSummary
+	for _, b := range blocks {
+		res = append(res, blockSummary{b.Server, b.Share, b.Dialect, b.Disconnected, b.SMBs, b.BytesRead, b.BytesWritten, b.HasBytes})
+	}
+	return res
+}
+
+func TestParseClientStatsExamples(t *testing.T) {
+	tests := []struct {
+		file     string
+		header   Header
+		blocks   []blockSummary
+		inFlight []ServerInFlight
+	}{
+		{
+			file: "example1.txt",
+			header: Header{CIFSSession: 1, Targets: 2, SMBReq: 1, SMBBuf: 5, SMBSmallReq: 1, SMBSmallBuf: 30,
+				Op: 0, Session: 0, ShareReconnects: 0, MaxOp: 16, AtOnce: 2},
+			blocks: []blockSummary{
+				{"server1", "share1", DialectSMB1, false, 9, 0, 0, true},
+				{"server2", "share2", DialectSMB2, false, 20, 0, 0, false},
+				{"server", "share3", DialectSMB1, false, 9, 0, 0, true},
+			},
+		},
+		{
+			file: "example2.txt",
+			header: Header{CIFSSession: 2, Targets: 3, SMBReq: 2, SMBBuf: 6, SMBSmallReq: 2, SMBSmallBuf: 30,
+				Op: 1, Session: 3, ShareReconnects: 5, MaxOp: 4242, AtOnce: 4},
+			blocks: []blockSummary{
+				{"10.0.0.5", "data", DialectSMB2, false, 3101, 1048576, 524288, true},
+				{"file_srv.example.com", "projects", DialectSMB2, true, 12, 0, 0, true},
+				{"file_srv.example.com", "home", DialectSMB2, false, 44, 8192, 0, true},
+			},
+			inFlight: []ServerInFlight{{"10.0.0.5", 2}, {"file_srv.example.com", 5}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			stats, err := ParseClientStatsStrict(openExample(t, test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.Header != test.header {
+				t.Errorf("header = %+v, want %+v", stats.Header, test.header)
+			}
+			if got := summarize(stats.Blocks); !reflect.DeepEqual(got, test.blocks) {
+				t.Errorf("blocks = %+v, want %+v", got, test.blocks)
+			}
+			var inFlight []ServerInFlight
+			for _, f := range stats.InFlight {
+				inFlight = append(inFlight, *f)
+			}
+			if !reflect.DeepEqual(inFlight, test.inFlight) {
+				t.Errorf("in flight = %+v, want %+v", inFlight, test.inFlight)
+			}
+			if stats.Stats2 {
+				t.Errorf("Stats2 is set")
+			}
+		})
+	}
+}
+
+func TestParseClientStatsCounters(t *testing.T) {
+	stats, err := ParseClientStatsStrict(openExample(t, "example1.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	smb1, smb2 := stats.Blocks[0], stats.Blocks[1]
+	for name, want := range map[string]uint64{"T2 Renames": 0, "FindFirst": 1, "Posix Mkdirs": 0, "FClose": 0} {
+		if got, ok := smb1.Counters[name]; !ok || got != want {
+			t.Errorf("SMB1 counter %q = %d, %v, want %d", name, got, ok, want)
+		}
+	}
+	if got := stats.Blocks[2].Counters["Locks"]; got != 99 {
+		t.Errorf("Locks = %d, want 99", got)
+	}
+	if got, want := smb2.Commands["Creates"], (Command{Sent: 0, Failed: 2}); got != want {
+		t.Errorf("Creates = %+v, want %+v", got, want)
+	}
+	if got := len(smb2.Commands); got != 19 {
+		t.Errorf("got %d SMB2 commands, want 19", got)
+	}
+
+	stats, err = ParseClientStatsStrict(openExample(t, "example2.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := stats.Blocks[0]
+	if got, want := data.Commands["QueryInfos"], (Command{Sent: 2400, Failed: 12}); got != want {
+		t.Errorf("QueryInfos = %+v, want %+v", got, want)
+	}
+	if data.Counters["Open files"] != 3 || data.Counters["Open files on server"] != 2 {
+		t.Errorf("open files = %d/%d, want 3/2", data.Counters["Open files"], data.Counters["Open files on server"])
+	}
+	if data.OplockBreaks != 3 {
+		t.Errorf("OplockBreaks = %d, want 3", data.OplockBreaks)
+	}
+}
+
+func TestParseClientStatsMaxInFlight(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		inFlight []ServerInFlight
+		smbs     []uint64
+		warnings []string
+	}{
+		{
+			name:     "in front of the blocks",
+			input:    "Total vfs operations: 1 maximum at one time: 1\n\nMax requests in flight: 2\n1) \\\\a\\x\nSMBs: 1\nMax requests in flight: 5\n2) \\\\b\\y\nSMBs: 2\n",
+			inFlight: []ServerInFlight{{"a", 2}, {"b", 5}},
+			smbs:     []uint64{1, 2},
+		},
+		{
+			name:     "connection without blocks",
+			input:    "Max requests in flight: 2\nMax requests in flight: 3\n1) \\\\b\\y\nSMBs: 2\n",
+			inFlight: []ServerInFlight{{"", 2}, {"b", 3}},
+			smbs:     []uint64{2},
+		},
+		{
+			name:     "ends the block",
+			input:    "1) \\\\a\\x\nSMBs: 1\nMax requests in flight: 4\nSMBs: 3\n",
+			inFlight: []ServerInFlight{{"", 4}},
+			smbs:     []uint64{1},
+			warnings: []string{ReasonOutsideBlock},
+		},
+		{
+			name:     "invalid value",
+			input:    "Max requests in flight: many\n1) \\\\a\\x\nSMBs: 1\n",
+			smbs:     []uint64{1},
+			warnings: []string{ReasonInvalidValue},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats, err := ParseClientStats(strings.NewReader(test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var inFlight []ServerInFlight
+			for _, f := range stats.InFlight {
+				inFlight = append(inFlight, *f)
+			}
+			if !reflect.DeepEqual(inFlight, test.inFlight) {
+				t.Errorf("in flight = %+v, want %+v", inFlight, test.inFlight)
+			}
+			var smbs []uint64
+			for _, b := range stats.Blocks {
+				smbs = append(smbs, b.SMBs)
+			}
+			if !reflect.DeepEqual(smbs, test.smbs) {
+				t.Errorf("SMBs = %v, want %v", smbs, test.smbs)
+			}
+			var reasons []string
+			for _, w := range stats.Warnings {
+				reasons = append(reasons, w.Reason)
+			}
+			if !reflect.DeepEqual(reasons, test.warnings) {
+				t.Errorf("warnings = %v, want %v", reasons, test.warnings)
+			}
+		})
+	}
+}
+
+func TestParseClientStatsLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		smbs     uint64
+		warnings []string
+	}{
+		{
+			name:  "SMBs since",
+			input: "1) \\\\server\\share\nSMBs: 12 since 2024-03-11 08:15:02 UTC\n",
+			smbs:  12,
+		},
+		{
+			name:     "unknown line in block",
+			input:    "1) \\\\server\\share\nSMBs: 12\nSomething new\n",
+			smbs:     12,
+			warnings: []string{ReasonUnknownLine},
+		},
+		{
+			name:     "negative value",
+			input:    "1) \\\\server\\share\nSMBs: 12\nReads: -1 Bytes: 0\n",
+			smbs:     12,
+			warnings: []string{ReasonInvalidValue},
+		},
+		{
+			name:     "line between blocks",
+			input:    "1) \\\\server\\share\nSMBs: 12\n\nSMBs: 13\n",
+			smbs:     12,
+			warnings: []string{ReasonOutsideBlock},
+		},
+		{
+			name:  "CRLF and indentation",
+			input: "1) \\\\server\\share\r\n   SMBs: 7   \r\n",
+			smbs:  7,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats, err := ParseClientStats(strings.NewReader(test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stats.Blocks) != 1 {
+				t.Fatalf("got %d blocks, want 1", len(stats.Blocks))
+			}
+			if stats.Blocks[0].SMBs != test.smbs {
+				t.Errorf("SMBs = %d, want %d", stats.Blocks[0].SMBs, test.smbs)
+			}
+			var reasons []string
+			for _, w := range stats.Warnings {
+				reasons = append(reasons, w.Reason)
+			}
+			if !reflect.DeepEqual(reasons, test.warnings) {
+				t.Errorf("warnings = %v, want %v", reasons, test.warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package cifs
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Consumer is a CIFS mount in a mount namespace, together with the cgroup of the processes
+// using the namespace. On Kubernetes nodes every container has its own mount namespace, so
+// the cgroup tells us which pod and container use a share.
+type Consumer struct {
+	Mount *Mount
+	// MountNamespace is the inode number of the mount namespace, for example 4026532561.
+	MountNamespace string
+	// PID is the first process we found in the mount namespace.
+	PID int
+	// Cgroup is the cgroup path of PID, for example /kubepods.slice/kubepods-pod<uid>.slice/cri-containerd-<id>.scope.
+	Cgroup string
+	// PodUID and ContainerID are taken from the cgroup path and are empty if it does not contain them.
+	PodUID      string
+	ContainerID string
+}
+
+// These regexes find pod UIDs and container IDs in the cgroup paths of the common container runtimes.
+// The systemd cgroup driver replaces the dashes of the pod UID with underscores.
+var (
+	podUID      = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+	containerID = regexp.MustCompile(`(?:^|[/-])([0-9a-f]{64})(?:\.scope)?$`)
+)
+
+// Consumers reads the mountinfo of every mount namespace below the proc mount point and returns
+// all CIFS mounts with the namespace they are mounted in. We read the mountinfo of the first process
+// of every namespace only, all other processes in the namespace see the same mounts.
+// Processes we are not allowed to inspect or that exit while we read them are skipped.
+func (fs FS) Consumers() ([]*Consumer, error) {
+	pids, err := fs.pids()
+	if err != nil {
+		return nil, err
+	}
+	var consumers []*Consumer
+	seen := map[string]bool{}
+	for _, pid := range pids {
+		p := strconv.Itoa(pid)
+		ns, err := os.Readlink(fs.Path(p, "ns", "mnt"))
+		if err != nil {
+			continue
+		}
+		// The link looks like mnt:[4026531840]
+		ns = strings.TrimSuffix(strings.TrimPrefix(ns, "mnt:["), "]")
+		if seen[ns] {
+			continue
+		}
+		f, err := os.Open(fs.Path(p, "mountinfo"))
+		if err != nil {
+			continue
+		}
+		mounts, err := ParseMountInfo(f)
+		f.Close()
+		if err != nil {
+			continue
+		}
+		seen[ns] = true
+		if len(mounts) == 0 {
+			continue
+		}
+		cgroup := ""
+		if f, err := os.Open(fs.Path(p, "cgroup")); err == nil {
+			cgroup = parseCgroup(f)
+			f.Close()
+		}
+		for _, m := range mounts {
+			c := &Consumer{Mount: m, MountNamespace: ns, PID: pid, Cgroup: cgroup}
+			c.PodUID, c.ContainerID = cgroupIDs(cgroup)
+			consumers = append(consumers, c)
+		}
+	}
+	return consumers, nil
+}
+
+// cgroupIDs returns the pod UID and the container ID of a cgroup path, they are empty if it does not contain them.
+func cgroupIDs(cgroup string) (pod, container string) {
+	if match := podUID.FindStringSubmatch(cgroup); match != nil {
+		pod = strings.ReplaceAll(match[1], "_", "-")
+	}
+	if match := containerID.FindStringSubmatch(cgroup); match != nil {
+		container = match[1]
+	}
+	return pod, container
+}
+
+// pids returns all process IDs below the proc mount point in ascending order.
+func (fs FS) pids() ([]int, error) {
+	entries, err := os.ReadDir(fs.Path())
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, e := range entries {
+		if pid, err := strconv.Atoi(e.Name()); err == nil && e.IsDir() {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+	return pids, nil
+}
+
+// parseCgroup returns the cgroup path of a /proc/<pid>/cgroup file. Lines look like
+// hierarchy-ID:controllers:path. We prefer the unified hierarchy of cgroup v2 ("0::/path"),
+// then the systemd hierarchy of cgroup v1, then the first line.
+func parseCgroup(r io.Reader) string {
+	var first, systemd string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		switch {
+		case fields[0] == "0" && fields[1] == "":
+			return fields[2]
+		case fields[1] == "name=systemd":
+			systemd = fields[2]
+		case first == "":
+			first = fields[2]
+		}
+	}
+	if systemd != "" {
+		return systemd
+	}
+	return first
+}
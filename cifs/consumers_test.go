@@ -0,0 +1,154 @@
+package cifs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	testContainerID = "4f2a9c1e0b7d3c5a8e6f1d2b9a0c7e4f3b5d8a1c6e9f2b4d7a0c3e5f8b1d4a6c"
+	testDockerID    = "9b1e5d3a7c0f2e4b6d8a1c3e5f7b9d0a2c4e6f8b1d3a5c7e9f0b2d4a6c8e1f3b"
+)
+
+// fakeProcess is a process below a fake proc mount point.
+type fakeProcess struct {
+	pid, ns, mountinfo, cgroup string
+}
+
+// newFakeProc builds /proc/<pid>/{ns/mnt,mountinfo,cgroup} for every process.
+// An empty ns leaves out the namespace link, like for processes we are not allowed to inspect.
+func newFakeProc(t *testing.T, processes []fakeProcess) FS {
+	t.Helper()
+	proc := t.TempDir()
+	for _, p := range processes {
+		dir := filepath.Join(proc, p.pid)
+		if err := os.MkdirAll(filepath.Join(dir, "ns"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if p.ns != "" {
+			if err := os.Symlink("mnt:["+p.ns+"]", filepath.Join(dir, "ns", "mnt")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for name, content := range map[string]string{"mountinfo": p.mountinfo, "cgroup": p.cgroup} {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// Files and directories without a PID are no processes
+	if err := os.WriteFile(filepath.Join(proc, "uptime"), []byte("1.00 2.00\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(proc, "self"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := NewFS(proc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func TestConsumers(t *testing.T) {
+	root := "22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw\n"
+	data := "36 22 0:45 / /mnt/data rw,relatime shared:101 - cifs //srv/data rw,vers=3.1.1\n"
+	fs := newFakeProc(t, []fakeProcess{
+		{pid: "1", ns: "4026531840", mountinfo: root + data, cgroup: "0::/init.scope\n"},
+		// Same namespace as PID 1, we don't read its mountinfo
+		{pid: "95", ns: "4026531840", mountinfo: root + "37 22 0:46 / /mnt/other rw - cifs //srv/other rw\n", cgroup: "0::/user.slice\n"},
+		// Kubernetes with cgroup v2 and the systemd cgroup driver
+		{pid: "200", ns: "4026532561",
+			mountinfo: "50 40 0:45 /team /data rw,relatime - cifs //srv/data/team rw,vers=3.1.1\n",
+			cgroup:    "0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1b4e28ba_2fa1_11d2_883f_0016d3cca427.slice/cri-containerd-" + testContainerID + ".scope\n"},
+		// Kubernetes with cgroup v1 and the cgroupfs driver. The mountinfo of PID 300 is invalid,
+		// so we take the next process of the namespace.
+		{pid: "300", ns: "4026532600", mountinfo: "invalid\n"},
+		{pid: "301", ns: "4026532600",
+			mountinfo: "60 40 0:47 / /archive ro,relatime - smb3 //backup/archive ro,vers=3.0\n",
+			cgroup: "12:memory:/kubepods/besteffort/pod7c9e6679-7425-40de-944b-e07fc1f90ae7/" + testContainerID + "\n" +
+				"1:name=systemd:/kubepods/besteffort/pod7c9e6679-7425-40de-944b-e07fc1f90ae7/" + testContainerID + "\n"},
+		// Docker without Kubernetes
+		{pid: "400", ns: "4026532700", mountinfo: data, cgroup: "0::/system.slice/docker-" + testDockerID + ".scope\n"},
+		// A namespace without CIFS mounts
+		{pid: "500", ns: "4026532800", mountinfo: root, cgroup: "0::/system.slice/sshd.service\n"},
+		// A process we are not allowed to inspect
+		{pid: "600", mountinfo: data, cgroup: "0::/secret.scope\n"},
+	})
+	consumers, err := fs.Consumers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	type consumer struct {
+		mountPoint, server, share, ns string
+		pid                           int
+		podUID, containerID           string
+	}
+	want := []consumer{
+		{"/mnt/data", "srv", "data", "4026531840", 1, "", ""},
+		{"/data", "srv", "data", "4026532561", 200, "1b4e28ba-2fa1-11d2-883f-0016d3cca427", testContainerID},
+		{"/archive", "backup", "archive", "4026532600", 301, "7c9e6679-7425-40de-944b-e07fc1f90ae7", testContainerID},
+		{"/mnt/data", "srv", "data", "4026532700", 400, "", testDockerID},
+	}
+	var got []consumer
+	for _, c := range consumers {
+		got = append(got, consumer{c.Mount.MountPoint, c.Mount.Server, c.Mount.Share, c.MountNamespace, c.PID, c.PodUID, c.ContainerID})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestConsumersMissingProc(t *testing.T) {
+	fs := FS{proc: filepath.Join(t.TempDir(), "missing")}
+	if _, err := fs.Consumers(); err == nil {
+		t.Error("no error for a missing proc mount point")
+	}
+}
+
+func TestParseCgroup(t *testing.T) {
+	tests := []struct {
+		name, cgroup, want string
+	}{
+		{"v2", "0::/kubepods.slice/cri-containerd-abc.scope\n", "/kubepods.slice/cri-containerd-abc.scope"},
+		{"v1 systemd", "12:memory:/docker/abc\n11:cpu,cpuacct:/docker/abc\n1:name=systemd:/system.slice/docker-abc.scope\n", "/system.slice/docker-abc.scope"},
+		{"v1 without systemd", "12:memory:/docker/abc\n11:cpu,cpuacct:/docker/def\n", "/docker/abc"},
+		{"hybrid", "1:name=systemd:/user.slice\n0::/system.slice/docker-abc.scope\n", "/system.slice/docker-abc.scope"},
+		{"invalid lines", "garbage\n\n3:pids:/kubepods/pod1\n", "/kubepods/pod1"},
+		{"empty", "", ""},
+	}
+	for _, test := range tests {
+		if got := parseCgroup(strings.NewReader(test.cgroup)); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCgroupIDs(t *testing.T) {
+	tests := []struct {
+		name, cgroup, pod, container string
+	}{
+		{"systemd driver, containerd",
+			"/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod1b4e28ba_2fa1_11d2_883f_0016d3cca427.slice/cri-containerd-" + testContainerID + ".scope",
+			"1b4e28ba-2fa1-11d2-883f-0016d3cca427", testContainerID},
+		{"systemd driver, cri-o",
+			"/kubepods.slice/kubepods-pod1b4e28ba_2fa1_11d2_883f_0016d3cca427.slice/crio-" + testContainerID + ".scope",
+			"1b4e28ba-2fa1-11d2-883f-0016d3cca427", testContainerID},
+		{"cgroupfs driver",
+			"/kubepods/burstable/pod7c9e6679-7425-40de-944b-e07fc1f90ae7/" + testContainerID,
+			"7c9e6679-7425-40de-944b-e07fc1f90ae7", testContainerID},
+		{"pod sandbox cgroup", "/kubepods/burstable/pod7c9e6679-7425-40de-944b-e07fc1f90ae7", "7c9e6679-7425-40de-944b-e07fc1f90ae7", ""},
+		{"docker systemd", "/system.slice/docker-" + testDockerID + ".scope", "", testDockerID},
+		{"docker cgroupfs", "/docker/" + testDockerID, "", testDockerID},
+		{"short ID", "/docker/4f2a9c1e0b7d", "", ""},
+		{"host", "/user.slice/user-1000.slice/session-2.scope", "", ""},
+	}
+	for _, test := range tests {
+		if pod, container := cgroupIDs(test.cgroup); pod != test.pod || container != test.container {
+			t.Errorf("%s: got %q %q, want %q %q", test.name, pod, container, test.pod, test.container)
+		}
+	}
+}
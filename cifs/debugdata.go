@@ -0,0 +1,340 @@
+package cifs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// StatusGood is the kernel status for healthy connections, sessions and shares.
+// All other values mean the object is new, exiting or waiting for a reconnect.
+const StatusGood = 1
+
+// DebugData describes the CIFS debug file /proc/fs/cifs/DebugData.
+// It holds the operational state of every connection, session and share.
+type DebugData struct {
+	Version           string
+	Features          []string
+	MaxBufSize        uint64
+	ActiveVFSRequests uint64
+	Connections       []*Connection
+	// Warnings holds every line with a value we could not parse.
+	// DebugData has a lot of lines we don't need, we don't warn about those.
+	Warnings []LineError
+}
+
+// ConnectionState stores the state the kernel prints for connections and their extra channels.
+type ConnectionState struct {
+	ConnectionID uint64
+	Credits      uint64
+	// Dialect is the negotiated dialect as printed by the kernel, for example 0x311.
+	Dialect      uint64
+	Status       uint64
+	Instance     uint64
+	LocalUsers   uint64
+	SecMode      uint64
+	InFlight     uint64
+	InSend       uint64
+	InMaxReqWait uint64
+}
+
+// Up reports whether the TCP connection is established.
+func (c ConnectionState) Up() bool {
+	return c.Status == StatusGood
+}
+
+// Connection stores a single TCP connection to a server.
+type Connection struct {
+	ConnectionState
+	Hostname     string
+	Capabilities uint64
+	Sessions     []*Session
+}
+
+// Session stores a SMB session on top of a connection.
+type Session struct {
+	Address      string
+	Uses         uint64
+	Capability   uint64
+	Status       uint64
+	SecurityType string
+	SessionID    uint64
+	Encrypted    bool
+	Signed       bool
+	User         uint64
+	CredUser     uint64
+	// Channels holds the extra channels of a multichannel session.
+	Channels []*Channel
+	Shares   []*Tree
+}
+
+// Channel stores an extra channel of a multichannel session.
+type Channel struct {
+	ConnectionState
+	Number uint64
+}
+
+// Tree stores a tree connection, that's a single share of a session.
+type Tree struct {
+	Server       string
+	Share        string
+	IPC          bool
+	Mounts       uint64
+	Status       uint64
+	Type         string
+	TreeID       uint64
+	Disconnected bool
+}
+
+// DialectString returns the SMB version of a dialect as printed by the kernel, for example 3.1.1 for 0x311.
+func DialectString(dialect uint64) string {
+	switch dialect {
+	case 0:
+		return "unknown"
+	case 0x202:
+		return "2.0.2"
+	case 0x210:
+		return "2.1"
+	case 0x300:
+		return "3.0"
+	case 0x302:
+		return "3.0.2"
+	case 0x311:
+		return "3.1.1"
+	default:
+		return fmt.Sprintf("0x%x", dialect)
+	}
+}
+
+// These regexes match the lines that start a new connection, session, channel or share.
+var (
+	connectionHeader = regexp.MustCompile(`^\d+\) (?:ConnectionId: (0x[0-9a-fA-F]+) )?(?:Hostname|Name): (\S+)`)
+	sessionHeader    = regexp.MustCompile(`^\d+\) Address: (\S+) Uses: (\d+) Capability: (0x[0-9a-fA-F]+)\s+Session Status: (\d+)`)
+	channelHeader    = regexp.MustCompile(`^Channel: (\d+) ConnectionId: (0x[0-9a-fA-F]+)`)
+	treeHeader       = regexp.MustCompile(`^\d+\) (IPC: )?(\\\\.+?) Mounts: (\d+)`)
+)
+
+// connectionFields are the values printed for connections and their extra channels.
+var connectionFields = []struct {
+	re  *regexp.Regexp
+	set func(c *ConnectionState, v uint64)
+}{
+	{regexp.MustCompile(`Number of credits: (\d+)`), func(c *ConnectionState, v uint64) { c.Credits = v }},
+	{regexp.MustCompile(`Dialect (0x[0-9a-fA-F]+)`), func(c *ConnectionState, v uint64) { c.Dialect = v }},
+	{regexp.MustCompile(`TCP status: (\d+)`), func(c *ConnectionState, v uint64) { c.Status = v }},
+	{regexp.MustCompile(`Instance: (\d+)`), func(c *ConnectionState, v uint64) { c.Instance = v }},
+	{regexp.MustCompile(`Local Users To Server: (\d+)`), func(c *ConnectionState, v uint64) { c.LocalUsers = v }},
+	{regexp.MustCompile(`SecMode: (0x[0-9a-fA-F]+)`), func(c *ConnectionState, v uint64) { c.SecMode = v }},
+	{regexp.MustCompile(`Req On Wire: (\d+)`), func(c *ConnectionState, v uint64) { c.InFlight = v }},
+	{regexp.MustCompile(`In Send: (\d+)`), func(c *ConnectionState, v uint64) { c.InSend = v }},
+	{regexp.MustCompile(`In MaxReq Wait: (\d+)`), func(c *ConnectionState, v uint64) { c.InMaxReqWait = v }},
+}
+
+// sessionFields are the values printed for sessions.
+var sessionFields = []struct {
+	re  *regexp.Regexp
+	set func(s *Session, v uint64)
+}{
+	{regexp.MustCompile(`SessionId: (0x[0-9a-fA-F]+)`), func(s *Session, v uint64) { s.SessionID = v }},
+	{regexp.MustCompile(`User: (\d+) Cred User`), func(s *Session, v uint64) { s.User = v }},
+	{regexp.MustCompile(`Cred User: (\d+)`), func(s *Session, v uint64) { s.CredUser = v }},
+}
+
+// treeFields are the values printed for shares.
+var treeFields = []struct {
+	re  *regexp.Regexp
+	set func(t *Tree, v uint64)
+}{
+	{regexp.MustCompile(`PathComponentMax: \d+ Status: (\d+)`), func(t *Tree, v uint64) { t.Status = v }},
+	{regexp.MustCompile(`tid: (0x[0-9a-fA-F]+)`), func(t *Tree, v uint64) { t.TreeID = v }},
+}
+
+var serverCapabilities = regexp.MustCompile(`Server capabilities: (0x[0-9a-fA-F]+)`)
+
+// The sections of DebugData, so we know which object a line belongs to.
+const (
+	sectionHeader = iota
+	sectionConnection
+	sectionSession
+	sectionShares
+	sectionIgnored
+)
+
+// DebugData opens fs/cifs/DebugData below the proc mount point and returns the parsed debug data.
+func (fs FS) DebugData() (*DebugData, error) {
+	f, err := os.Open(fs.Path("fs", "cifs", "DebugData"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseDebugData(f)
+}
+
+// parseUint parses decimal and 0x prefixed hex values.
+func parseUint(s string) (uint64, error) {
+	return strconv.ParseUint(s, 0, 64)
+}
+
+// ParseDebugData scans the CIFS debug file line by line.
+// The file is nested: connections have sessions, sessions have channels and shares.
+// We remember the last connection, session, channel and share and store every value we find
+// in the object we are in. Lines we don't know are ignored, because the kernel prints a lot
+// of details we don't need.
+func ParseDebugData(r io.Reader) (*DebugData, error) {
+	data := &DebugData{}
+	scanner := bufio.NewScanner(r)
+	section := sectionHeader
+	var (
+		conn    *Connection
+		session *Session
+		channel *Channel
+		tree    *Tree
+	)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		// value parses a matched value and remembers it if it is invalid
+		value := func(s string) (uint64, bool) {
+			v, err := parseUint(s)
+			if err != nil {
+				data.Warnings = append(data.Warnings, LineError{Line: n, Text: line, Reason: ReasonInvalidValue})
+				return 0, false
+			}
+			return v, true
+		}
+		// set stores all matched values of a line in targets
+		set := func(matches []string, targets ...*uint64) {
+			for i, t := range targets {
+				if v, ok := value(matches[i]); ok {
+					*t = v
+				}
+			}
+		}
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "CIFS Version "):
+			data.Version = strings.TrimPrefix(line, "CIFS Version ")
+			continue
+		case strings.HasPrefix(line, "Features: "):
+			data.Features = strings.Split(strings.TrimPrefix(line, "Features: "), ",")
+			continue
+		case strings.HasPrefix(line, "CIFSMaxBufSize: "):
+			set([]string{strings.TrimPrefix(line, "CIFSMaxBufSize: ")}, &data.MaxBufSize)
+			continue
+		case strings.HasPrefix(line, "Active VFS Requests: "):
+			set([]string{strings.TrimPrefix(line, "Active VFS Requests: ")}, &data.ActiveVFSRequests)
+			continue
+		case conn == nil && !connectionHeader.MatchString(line):
+			continue
+		case line == "Sessions:":
+			section, channel = sectionSession, nil
+			continue
+		case line == "Shares:":
+			section, channel = sectionShares, nil
+			continue
+		case strings.HasPrefix(line, "Server interfaces:"), line == "MIDs:":
+			section, channel = sectionIgnored, nil
+			continue
+		}
+		if m := connectionHeader.FindStringSubmatch(line); m != nil {
+			conn = &Connection{Hostname: m[2]}
+			if m[1] != "" {
+				set(m[1:2], &conn.ConnectionID)
+			}
+			data.Connections = append(data.Connections, conn)
+			session, channel, tree = nil, nil, nil
+			section = sectionConnection
+			continue
+		}
+		switch section {
+		case sectionConnection:
+			if m := serverCapabilities.FindStringSubmatch(line); m != nil {
+				set(m[1:], &conn.Capabilities)
+			}
+			for _, f := range connectionFields {
+				if m := f.re.FindStringSubmatch(line); m != nil {
+					if v, ok := value(m[1]); ok {
+						f.set(&conn.ConnectionState, v)
+					}
+				}
+			}
+		case sectionSession:
+			if m := sessionHeader.FindStringSubmatch(line); m != nil {
+				session = &Session{Address: m[1]}
+				set(m[2:5], &session.Uses, &session.Capability, &session.Status)
+				conn.Sessions = append(conn.Sessions, session)
+				channel, tree = nil, nil
+				continue
+			}
+			if session == nil {
+				continue
+			}
+			if m := channelHeader.FindStringSubmatch(line); m != nil {
+				channel = &Channel{}
+				set(m[1:3], &channel.Number, &channel.ConnectionID)
+				session.Channels = append(session.Channels, channel)
+				continue
+			}
+			if channel != nil {
+				for _, f := range connectionFields {
+					if m := f.re.FindStringSubmatch(line); m != nil {
+						if v, ok := value(m[1]); ok {
+							f.set(&channel.ConnectionState, v)
+						}
+					}
+				}
+				continue
+			}
+			if strings.HasPrefix(line, "Security type: ") {
+				session.SecurityType = strings.Fields(strings.TrimPrefix(line, "Security type: "))[0]
+				session.Encrypted = strings.Contains(line, " encrypted")
+				session.Signed = strings.Contains(line, " signed")
+			}
+			for _, f := range sessionFields {
+				if m := f.re.FindStringSubmatch(line); m != nil {
+					if v, ok := value(m[1]); ok {
+						f.set(session, v)
+					}
+				}
+			}
+		case sectionShares:
+			if session == nil {
+				continue
+			}
+			if m := treeHeader.FindStringSubmatch(line); m != nil {
+				u, err := ParseUNC(m[2])
+				if err != nil {
+					data.Warnings = append(data.Warnings, LineError{Line: n, Text: line, Reason: ReasonInvalidValue})
+					tree = nil
+					continue
+				}
+				tree = &Tree{Server: u.Server, Share: u.Share, IPC: m[1] != ""}
+				set(m[3:4], &tree.Mounts)
+				session.Shares = append(session.Shares, tree)
+			}
+			if tree == nil {
+				continue
+			}
+			if strings.Contains(line, "DISCONNECTED") {
+				tree.Disconnected = true
+			}
+			if i := strings.Index(line, " type: "); i >= 0 {
+				tree.Type = strings.Fields(line[i+len(" type: "):])[0]
+			}
+			for _, f := range treeFields {
+				if m := f.re.FindStringSubmatch(line); m != nil {
+					if v, ok := value(m[1]); ok {
+						f.set(tree, v)
+					}
+				}
+			}
+		}
+	}
+	return data, scanner.Err()
+}
@@ -0,0 +1,115 @@
+package cifs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDebugDataExample(t *testing.T) {
+	data, err := ParseDebugData(openExample(t, "example2_debugdata.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Warnings) != 0 {
+		t.Errorf("unexpected warnings: %v", data.Warnings)
+	}
+	if data.Version != "2.45" || data.MaxBufSize != 16384 || data.ActiveVFSRequests != 1 {
+		t.Errorf("header = %q %d %d", data.Version, data.MaxBufSize, data.ActiveVFSRequests)
+	}
+	if len(data.Features) != 10 || data.Features[1] != "FSCACHE" {
+		t.Errorf("Features = %v", data.Features)
+	}
+	if len(data.Connections) != 2 {
+		t.Fatalf("got %d connections, want 2", len(data.Connections))
+	}
+
+	conns := []struct {
+		hostname     string
+		state        ConnectionState
+		capabilities uint64
+		sessions     int
+	}{
+		{"10.0.0.5", ConnectionState{ConnectionID: 1, Credits: 8190, Dialect: 0x311, Status: 1, Instance: 1,
+			LocalUsers: 1, SecMode: 1, InFlight: 1}, 0x300067, 1},
+		{"file_srv.example.com", ConnectionState{ConnectionID: 2, Credits: 0, Dialect: 0x302, Status: 3, Instance: 4,
+			LocalUsers: 2, SecMode: 3}, 0x300047, 1},
+	}
+	for i, want := range conns {
+		conn := data.Connections[i]
+		if conn.Hostname != want.hostname || conn.ConnectionState != want.state ||
+			conn.Capabilities != want.capabilities || len(conn.Sessions) != want.sessions {
+			t.Errorf("connection %d = %s %+v %#x %d sessions, want %+v", i, conn.Hostname, conn.ConnectionState,
+				conn.Capabilities, len(conn.Sessions), want)
+		}
+		if conn.Up() != (want.state.Status == StatusGood) {
+			t.Errorf("connection %d: Up() = %v", i, conn.Up())
+		}
+	}
+
+	session := data.Connections[0].Sessions[0]
+	if session.SessionID != 0x6c0e2a1c00000009 || session.SecurityType != "RawNTLMSSP" ||
+		!session.Encrypted || session.Signed || session.Status != 1 {
+		t.Errorf("session = %+v", session)
+	}
+	if len(session.Channels) != 1 || session.Channels[0].ConnectionID != 3 || session.Channels[0].Credits != 512 {
+		t.Errorf("channels = %+v", session.Channels)
+	}
+	session = data.Connections[1].Sessions[0]
+	if session.SecurityType != "Kerberos" || session.Encrypted || !session.Signed ||
+		session.User != 1000 || session.CredUser != 1000 || session.Status != 3 {
+		t.Errorf("session = %+v", session)
+	}
+
+	trees := []Tree{
+		{Server: "file_srv.example.com", Share: "IPC$", IPC: true, Mounts: 1, Status: 1, Type: "0", TreeID: 1},
+		{Server: "file_srv.example.com", Share: "projects", Mounts: 1, Status: 3, Type: "DISK", TreeID: 9, Disconnected: true},
+		{Server: "file_srv.example.com", Share: "home", Mounts: 1, Status: 1, Type: "DISK", TreeID: 0xd},
+	}
+	var got []Tree
+	for _, tree := range session.Shares {
+		got = append(got, *tree)
+	}
+	if !reflect.DeepEqual(got, trees) {
+		t.Errorf("trees = %+v, want %+v", got, trees)
+	}
+}
+
+func TestParseDebugDataInvalid(t *testing.T) {
+	input := `CIFS Version 2.45
+CIFSMaxBufSize: lots
+
+1) ConnectionId: 0x1 Hostname: srv
+Number of credits: 12,1,1 Dialect 0x311
+Server capabilities: 0xzz
+Sessions:
+1) Address: 10.0.0.1 Uses: 1 Capability: 0x1	Session Status: 1
+Shares:
+1) \\srv Mounts: 1 Type: NTFS
+`
+	data, err := ParseDebugData(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []int
+	for _, w := range data.Warnings {
+		lines = append(lines, w.Line)
+	}
+	if !reflect.DeepEqual(lines, []int{2, 10}) {
+		t.Errorf("warnings on lines %v, want [2 10]", lines)
+	}
+	if len(data.Connections) != 1 || data.Connections[0].Credits != 12 {
+		t.Fatalf("connections = %+v", data.Connections)
+	}
+	if shares := data.Connections[0].Sessions[0].Shares; len(shares) != 0 {
+		t.Errorf("got shares %+v for invalid UNC", shares)
+	}
+}
+
+func TestDialectString(t *testing.T) {
+	for dialect, want := range map[uint64]string{0: "unknown", 0x202: "2.0.2", 0x210: "2.1", 0x300: "3.0", 0x302: "3.0.2", 0x311: "3.1.1", 0x399: "0x399"} {
+		if got := DialectString(dialect); got != want {
+			t.Errorf("DialectString(%#x) = %q, want %q", dialect, got, want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package cifs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Reasons why a line could not be parsed. They are short and stable, so we can use them as labels.
+const (
+	// ReasonUnknownLine is used for lines we don't know at all.
+	ReasonUnknownLine = "unknown_line"
+	// ReasonInvalidValue is used for known lines with values we can't parse, for example negative numbers.
+	ReasonInvalidValue = "invalid_value"
+	// ReasonOutsideBlock is used for lines after the end of a block and before the next block header.
+	ReasonOutsideBlock = "outside_block"
+	// ReasonInvalidBlockHeader is used for block headers without a valid UNC path, the whole block is skipped.
+	ReasonInvalidBlockHeader = "invalid_block_header"
+)
+
+// LineError describes a single line we could not parse.
+type LineError struct {
+	// Line is the line number, starting at 1.
+	Line   int
+	Text   string
+	Reason string
+}
+
+func (e LineError) Error() string {
+	return fmt.Sprintf("line %d: %s: %q", e.Line, e.Reason, e.Text)
+}
+
+// ParseError lists all lines of a file we could not parse.
+// It is only returned by the strict parsers, the lenient parsers store the same lines as warnings.
+type ParseError struct {
+	Errors []LineError
+}
+
+func (e *ParseError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d malformed lines: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
@@ -0,0 +1,94 @@
+package cifs
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseClientStatsStrict(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		blocks  int
+		skipped int
+		errors  []LineError
+	}{
+		{
+			name:   "valid",
+			input:  "CIFS Session: 1\n\n1) \\\\server\\share\nSMBs: 1\n",
+			blocks: 1,
+		},
+		{
+			name:    "invalid block header",
+			input:   "1) \\\\server\\share\nSMBs: 1\n2) server-without-share\nSMBs: 2\nLocks: 3\n",
+			blocks:  1,
+			skipped: 1,
+			errors:  []LineError{{Line: 3, Text: "2) server-without-share", Reason: ReasonInvalidBlockHeader}},
+		},
+		{
+			name:   "unknown header line",
+			input:  "CIFS Session: 1\nNew kernel line: 1\n",
+			blocks: 0,
+			errors: []LineError{{Line: 2, Text: "New kernel line: 1", Reason: ReasonUnknownLine}},
+		},
+		{
+			name:   "several errors",
+			input:  "1) \\\\server\\share\nSMBs: x\nReads: -1 Bytes: 0\n",
+			blocks: 1,
+			errors: []LineError{
+				{Line: 2, Text: "SMBs: x", Reason: ReasonUnknownLine},
+				{Line: 3, Text: "Reads: -1 Bytes: 0", Reason: ReasonInvalidValue},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats, err := ParseClientStatsStrict(strings.NewReader(test.input))
+			if test.errors == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			} else {
+				var perr *ParseError
+				if !errors.As(err, &perr) {
+					t.Fatalf("got error %v, want *ParseError", err)
+				}
+				if !reflect.DeepEqual(perr.Errors, test.errors) {
+					t.Errorf("errors = %+v, want %+v", perr.Errors, test.errors)
+				}
+			}
+			// The strict parser returns the partially parsed statistics as well
+			if stats == nil {
+				t.Fatal("no statistics returned")
+			}
+			if len(stats.Blocks) != test.blocks {
+				t.Errorf("got %d blocks, want %d", len(stats.Blocks), test.blocks)
+			}
+			if stats.SkippedBlocks != test.skipped {
+				t.Errorf("SkippedBlocks = %d, want %d", stats.SkippedBlocks, test.skipped)
+			}
+
+			// The lenient parser stores the same lines as warnings
+			lenient, err := ParseClientStats(strings.NewReader(test.input))
+			if err != nil {
+				t.Fatalf("lenient parser returned %v", err)
+			}
+			if len(lenient.Warnings) != len(test.errors) {
+				t.Errorf("got %d warnings, want %d", len(lenient.Warnings), len(test.errors))
+			}
+		})
+	}
+}
+
+func TestParseErrorMessage(t *testing.T) {
+	err := &ParseError{Errors: []LineError{
+		{Line: 2, Text: "SMBs: x", Reason: ReasonInvalidValue},
+		{Line: 7, Text: "foo", Reason: ReasonUnknownLine},
+	}}
+	want := `2 malformed lines: line 2: invalid_value: "SMBs: x"; line 7: unknown_line: "foo"`
+	if got := err.Error(); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,51 @@
+package cifs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// DefaultProcMountPoint is the common mount point of the proc filesystem.
+const DefaultProcMountPoint = "/proc"
+
+// FS gives access to the CIFS files below a proc filesystem mount point.
+// This way we can read the host's /proc mounted somewhere else, for example in a container.
+type FS struct {
+	proc string
+}
+
+// NewFS returns a new FS for the proc filesystem mounted at mountPoint.
+// It returns an error if the mount point is not a directory.
+func NewFS(mountPoint string) (FS, error) {
+	info, err := os.Stat(mountPoint)
+	if err != nil {
+		return FS{}, fmt.Errorf("could not read %s: %w", mountPoint, err)
+	}
+	if !info.IsDir() {
+		return FS{}, fmt.Errorf("mount point %s is not a directory", mountPoint)
+	}
+	return FS{proc: mountPoint}, nil
+}
+
+// Path appends the given path elements to the proc mount point.
+func (fs FS) Path(p ...string) string {
+	return filepath.Join(append([]string{fs.proc}, p...)...)
+}
+
+// ClientStats opens fs/cifs/Stats below the proc mount point and returns our parsed CIFS client statistics.
+func (fs FS) ClientStats() (*ClientStats, error) {
+	f, err := os.Open(fs.Path("fs", "cifs", "Stats"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseClientStats(f)
+}
+
+// ModuleLoaded reports whether the CIFS kernel module is loaded.
+// The module creates fs/cifs below the proc mount point when it is loaded.
+func (fs FS) ModuleLoaded() bool {
+	info, err := os.Stat(fs.Path("fs", "cifs"))
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,129 @@
+package cifs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Mount stores a single CIFS mount from mountinfo.
+type Mount struct {
+	MountPoint string
+	// Source is the mount source as printed by the kernel, for example //server/share/dir.
+	Source string
+	FSType string
+	// Server and Share are parsed like in the Stats file, so metrics can be joined on them.
+	Server string
+	Share  string
+	// Path is the prefix path below the share, if only a directory of the share is mounted, for example /dir.
+	Path string
+	// Options are the per mount options like rw or relatime.
+	Options map[string]string
+	// SuperOptions are the CIFS specific options like vers, sec or cache.
+	SuperOptions map[string]string
+}
+
+// Option returns a CIFS option, it looks at the super options first and the mount options afterwards.
+func (m *Mount) Option(name string) string {
+	if v, ok := m.SuperOptions[name]; ok {
+		return v
+	}
+	return m.Options[name]
+}
+
+// Mounts reads the CIFS mounts of the init process below the proc mount point.
+// We read the mounts of PID 1, because they are the host's mounts even if we run in a container
+// with the host's /proc mounted. If we can't read them, we fall back to our own mounts.
+func (fs FS) Mounts() ([]*Mount, error) {
+	f, err := os.Open(fs.Path("1", "mountinfo"))
+	if err != nil {
+		f, err = os.Open(fs.Path("self", "mountinfo"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer f.Close()
+	return ParseMountInfo(f)
+}
+
+// isCIFS reports whether a filesystem type is one of the types of the CIFS kernel module.
+func isCIFS(fstype string) bool {
+	return fstype == "cifs" || fstype == "smb3"
+}
+
+// ParseMountInfo parses a mountinfo file and returns all CIFS mounts.
+// A mountinfo line looks as follows, the optional fields end with a single dash:
+// 36 35 0:45 / /mnt/share rw,relatime shared:1 - cifs //server/share rw,vers=3.1.1,sec=ntlmssp
+func ParseMountInfo(r io.Reader) ([]*Mount, error) {
+	var mounts []*Mount
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(fields) < 6 || sep < 0 || len(fields) < sep+3 {
+			return nil, fmt.Errorf("invalid mountinfo line %d: %q", n, scanner.Text())
+		}
+		if !isCIFS(fields[sep+1]) {
+			continue
+		}
+		m := &Mount{
+			MountPoint:   unescapeMountInfo(fields[4]),
+			Source:       unescapeMountInfo(fields[sep+2]),
+			FSType:       fields[sep+1],
+			Options:      parseMountOptions(fields[5]),
+			SuperOptions: map[string]string{},
+		}
+		if len(fields) > sep+3 {
+			m.SuperOptions = parseMountOptions(fields[sep+3])
+		}
+		if u, err := ParseUNC(m.Source); err == nil {
+			m.Server, m.Share, m.Path = u.Server, u.Share, u.Path
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, scanner.Err()
+}
+
+// parseMountOptions parses comma separated options, options without a value are stored with an empty value.
+func parseMountOptions(s string) map[string]string {
+	options := map[string]string{}
+	for _, o := range strings.Split(s, ",") {
+		kv := strings.SplitN(o, "=", 2)
+		if len(kv) == 2 {
+			options[kv[0]] = kv[1]
+		} else {
+			options[kv[0]] = ""
+		}
+	}
+	return options
+}
+
+// unescapeMountInfo replaces the octal escapes the kernel uses for spaces, tabs, newlines and backslashes.
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,72 @@
+package cifs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMountInfoExample(t *testing.T) {
+	mounts, err := ParseMountInfo(openExample(t, "example2_mountinfo.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		mountPoint, source, fstype string
+		server, share, path        string
+		vers, sec, cache           string
+	}{
+		{"/mnt/data", "//10.0.0.5/data", "cifs", "10.0.0.5", "data", "", "3.1.1", "ntlmssp", "strict"},
+		{"/mnt/team projects", "//file_srv.example.com/projects/team/a", "smb3", "file_srv.example.com", "projects", "/team/a", "3.0.2", "krb5", "none"},
+		{"/home/alice", "//file_srv.example.com/home", "cifs", "file_srv.example.com", "home", "", "3.0.2", "krb5", "strict"},
+		{"/mnt/v6", "//[2001:db8::5]/archive", "cifs", "2001:db8::5", "archive", "", "3.1.1", "ntlmssp", "strict"},
+	}
+	if len(mounts) != len(tests) {
+		t.Fatalf("got %d mounts, want %d", len(mounts), len(tests))
+	}
+	for i, want := range tests {
+		m := mounts[i]
+		if m.MountPoint != want.mountPoint || m.Source != want.source || m.FSType != want.fstype {
+			t.Errorf("mount %d = %q %q %q, want %q %q %q", i, m.MountPoint, m.Source, m.FSType, want.mountPoint, want.source, want.fstype)
+		}
+		if m.Server != want.server || m.Share != want.share || m.Path != want.path {
+			t.Errorf("mount %d = %q %q %q, want %q %q %q", i, m.Server, m.Share, m.Path, want.server, want.share, want.path)
+		}
+		for name, value := range map[string]string{"vers": want.vers, "sec": want.sec, "cache": want.cache} {
+			if got := m.Option(name); got != value {
+				t.Errorf("mount %d: option %s = %q, want %q", i, name, got, value)
+			}
+		}
+		// relatime is a mount option, Option falls back to them
+		if _, ok := m.Options["relatime"]; !ok {
+			t.Errorf("mount %d: relatime missing in %v", i, m.Options)
+		}
+	}
+}
+
+func TestParseMountInfoInvalid(t *testing.T) {
+	for _, line := range []string{
+		"36 29 0:45 / /mnt/data rw,relatime shared:101 cifs //10.0.0.5/data rw",
+		"36 29 0:45 / /mnt/data rw - cifs",
+		"36 29",
+	} {
+		if _, err := ParseMountInfo(strings.NewReader(line + "\n")); err == nil {
+			t.Errorf("no error for %q", line)
+		}
+	}
+}
+
+func TestUnescapeMountInfo(t *testing.T) {
+	for s, want := range map[string]string{
+		`/mnt/plain`:          "/mnt/plain",
+		`/mnt/a\040b`:         "/mnt/a b",
+		`/mnt/tab\011x`:       "/mnt/tab\tx",
+		`/mnt/back\134slash`:  `/mnt/back\slash`,
+		`/mnt/not\escape`:     `/mnt/not\escape`,
+		`/mnt/short\04`:       `/mnt/short\04`,
+		`/mnt/newline\012end`: "/mnt/newline\nend",
+	} {
+		if got := unescapeMountInfo(s); got != want {
+			t.Errorf("unescapeMountInfo(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
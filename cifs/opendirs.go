@@ -0,0 +1,134 @@
+package cifs
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// CachedDir is a cached directory handle from /proc/fs/cifs/open_dirs.
+// The kernel keeps a directory open with a lease, so it can answer lookups and listings
+// from its cache until the server breaks the lease.
+type CachedDir struct {
+	TreeID        uint64
+	SessionID     uint64
+	PersistentFID uint64
+	// Path is the path of the directory below the share with backslashes, it is empty for the root.
+	Path string
+	// FileInfoValid is set if the attributes of the directory are cached.
+	FileInfoValid bool
+	// DirentsValid is set if the complete listing of the directory is cached.
+	DirentsValid bool
+}
+
+// OpenDirs describes /proc/fs/cifs/open_dirs.
+type OpenDirs struct {
+	// Columns are the columns of the "# Format:" header, for example "tid" and "path".
+	Columns []string
+	Dirs    []*CachedDir
+	// Warnings holds every line we could not parse.
+	Warnings []LineError
+}
+
+// defaultOpenDirsColumns are the columns of the first kernels with open_dirs,
+// we use them if the file has no format header.
+var defaultOpenDirsColumns = []string{"tid", "sid", "persistent fid", "path"}
+
+// OpenDirs opens fs/cifs/open_dirs below the proc mount point and returns the cached directories.
+// Only kernels since 6.6 have the file.
+func (fs FS) OpenDirs() (*OpenDirs, error) {
+	f, err := os.Open(fs.Path("fs", "cifs", "open_dirs"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseOpenDirs(f)
+}
+
+// ParseOpenDirs parses open_dirs. Like in open_files we read the columns from the header.
+// The kernel prints the number of entries of every tree connection in front of its directories
+// and the state of the cache after a tab:
+//
+//	# Version:1
+//	# Format:
+//	# <tid> <sid> <persistent fid> <path>
+//	Num entries: 2
+//	0x5 0x6c0e2a1c00000009 0x2a1c0010
+//	0x5 0x6c0e2a1c00000009 0x2a1c0014     builds\x86	valid file info, valid dirents
+//
+// We count the directories ourselves, so we skip the "Num entries" lines.
+func ParseOpenDirs(r io.Reader) (*OpenDirs, error) {
+	dirs := &OpenDirs{Columns: defaultOpenDirsColumns}
+	scanner := bufio.NewScanner(r)
+	format := false
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			continue
+		case line == "# Format:":
+			format = true
+			continue
+		case strings.HasPrefix(line, "#"):
+			if format {
+				dirs.Columns, format = formatColumns(line), false
+			}
+			continue
+		case strings.HasPrefix(line, "Num entries:"):
+			continue
+		}
+		d, reason := parseCachedDir(line, dirs.Columns)
+		if reason != "" {
+			dirs.Warnings = append(dirs.Warnings, LineError{Line: n, Text: line, Reason: reason})
+			continue
+		}
+		dirs.Dirs = append(dirs.Dirs, d)
+	}
+	return dirs, scanner.Err()
+}
+
+// parseCachedDir parses a line of open_dirs with the given columns.
+// It returns the reason if the line is invalid.
+func parseCachedDir(line string, columns []string) (*CachedDir, string) {
+	d := &CachedDir{}
+	// The state of the cache follows after a tab, for example "valid file info, valid dirents"
+	if i := strings.Index(line, "\t"); i >= 0 {
+		for _, flag := range strings.Split(line[i+1:], ",") {
+			switch strings.TrimSpace(flag) {
+			case "valid file info":
+				d.FileInfoValid = true
+			case "valid dirents":
+				d.DirentsValid = true
+			}
+		}
+		line = line[:i]
+	}
+	values, ok := splitColumns(strings.Fields(line), columns, "path")
+	if !ok {
+		return nil, ReasonUnknownLine
+	}
+	targets := map[string]*uint64{
+		"tid":            &d.TreeID,
+		"sid":            &d.SessionID,
+		"persistent fid": &d.PersistentFID,
+	}
+	for i, c := range columns {
+		if c == "path" {
+			d.Path = values[i]
+			continue
+		}
+		t, ok := targets[c]
+		if !ok {
+			continue
+		}
+		v, err := parseUint(values[i])
+		if err != nil {
+			return nil, ReasonInvalidValue
+		}
+		*t = v
+	}
+	return d, ""
+}
@@ -0,0 +1,82 @@
+package cifs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOpenDirsExample(t *testing.T) {
+	dirs, err := ParseOpenDirs(openExample(t, "example2_open_dirs.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs.Warnings) != 0 {
+		t.Errorf("unexpected warnings: %v", dirs.Warnings)
+	}
+	if !reflect.DeepEqual(dirs.Columns, defaultOpenDirsColumns) {
+		t.Errorf("columns = %q, want %q", dirs.Columns, defaultOpenDirsColumns)
+	}
+	want := []CachedDir{
+		{TreeID: 5, SessionID: 0x6c0e2a1c00000009, PersistentFID: 0x2a1c0010},
+		{TreeID: 5, SessionID: 0x6c0e2a1c00000009, PersistentFID: 0x2a1c0014, Path: `builds\x86`, FileInfoValid: true, DirentsValid: true},
+		{TreeID: 5, SessionID: 0x6c0e2a1c00000009, PersistentFID: 0x2a1c0018, Path: `builds\release notes`, FileInfoValid: true},
+		{TreeID: 0xd, SessionID: 0x4400000000a1, PersistentFID: 0x11fa, Path: "Documents", FileInfoValid: true, DirentsValid: true},
+	}
+	var got []CachedDir
+	for _, d := range dirs.Dirs {
+		got = append(got, *d)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("dirs = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseOpenDirs(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		dirs     []CachedDir
+		warnings []string
+	}{
+		{
+			name:  "without header",
+			input: "Num entries: 1\n0x5 0x9 0x10 dir\n",
+			dirs:  []CachedDir{{TreeID: 5, SessionID: 9, PersistentFID: 0x10, Path: "dir"}},
+		},
+		{
+			name: "unknown column",
+			input: "# Format:\n# <tid> <sid> <persistent fid> <flags> <path>\n" +
+				"0x5 0x9 0x10 0x3 a b\tvalid dirents\n",
+			dirs: []CachedDir{{TreeID: 5, SessionID: 9, PersistentFID: 0x10, Path: "a b", DirentsValid: true}},
+		},
+		{
+			name:     "invalid lines",
+			input:    "0x5\n0x5 0xzz 0x10 dir\n0x6 0x9 0x11\n",
+			dirs:     []CachedDir{{TreeID: 6, SessionID: 9, PersistentFID: 0x11}},
+			warnings: []string{ReasonUnknownLine, ReasonInvalidValue},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dirs, err := ParseOpenDirs(strings.NewReader(test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []CachedDir
+			for _, d := range dirs.Dirs {
+				got = append(got, *d)
+			}
+			if !reflect.DeepEqual(got, test.dirs) {
+				t.Errorf("dirs = %+v, want %+v", got, test.dirs)
+			}
+			var reasons []string
+			for _, w := range dirs.Warnings {
+				reasons = append(reasons, w.Reason)
+			}
+			if !reflect.DeepEqual(reasons, test.warnings) {
+				t.Errorf("warnings = %v, want %v", reasons, test.warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package cifs
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// OpenFile is a single open file handle from /proc/fs/cifs/open_files.
+type OpenFile struct {
+	TreeID uint64
+	// SessionID is 0 on kernels which don't print it. Tree IDs are only unique per session.
+	SessionID     uint64
+	PersistentFID uint64
+	// Flags are the open flags, for example 0x8002 for O_RDWR|O_LARGEFILE.
+	Flags uint64
+	// Count is the number of references to the handle.
+	Count uint64
+	PID   uint64
+	UID   uint64
+	// Filename is the name of the file without its directory.
+	Filename string
+}
+
+// OpenFiles describes /proc/fs/cifs/open_files.
+type OpenFiles struct {
+	// Columns are the columns of the "# Format:" header, for example "tree id" and "filename".
+	Columns []string
+	Files   []*OpenFile
+	// Warnings holds every line we could not parse.
+	Warnings []LineError
+}
+
+// defaultOpenFilesColumns are the columns of the first kernels with open_files,
+// we use them if the file has no format header.
+var defaultOpenFilesColumns = []string{"tree id", "persistent fid", "flags", "count", "pid", "uid", "filename"}
+
+// formatColumn matches a column of the format header of open_files and open_dirs, for example <tree id>.
+var formatColumn = regexp.MustCompile(`<([^>]+)>`)
+
+// OpenFiles opens fs/cifs/open_files below the proc mount point and returns the parsed handles.
+// Kernels older than 5.1 don't have the file.
+func (fs FS) OpenFiles() (*OpenFiles, error) {
+	f, err := os.Open(fs.Path("fs", "cifs", "open_files"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseOpenFiles(f)
+}
+
+// ParseOpenFiles parses open_files. The columns changed over time, so we read them from the header:
+//
+//	# Version:1
+//	# Format:
+//	# <tree id> <ses id> <persistent fid> <flags> <count> <pid> <uid> <filename> <mid>
+//	0x5 0x6c0e2a1c00000009 0x2a1c 0x8002 1 4711 1000 report.xlsx 7
+//
+// Kernels built with CONFIG_CIFS_DEBUG2 print the mid after the filename. The filename may contain
+// spaces, so we take the columns in front of it from the start of the line and the columns after it
+// from the end. Unknown columns are skipped.
+func ParseOpenFiles(r io.Reader) (*OpenFiles, error) {
+	files := &OpenFiles{Columns: defaultOpenFilesColumns}
+	scanner := bufio.NewScanner(r)
+	format := false
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			continue
+		case line == "# Format:":
+			format = true
+			continue
+		case strings.HasPrefix(line, "#"):
+			// The line after "# Format:" lists the columns
+			if format {
+				files.Columns, format = formatColumns(line), false
+			}
+			continue
+		}
+		f, reason := parseOpenFile(line, files.Columns)
+		if reason != "" {
+			files.Warnings = append(files.Warnings, LineError{Line: n, Text: line, Reason: reason})
+			continue
+		}
+		files.Files = append(files.Files, f)
+	}
+	return files, scanner.Err()
+}
+
+// parseOpenFile parses a line of open_files with the given columns.
+// It returns the reason if the line is invalid.
+func parseOpenFile(line string, columns []string) (*OpenFile, string) {
+	values, ok := splitColumns(strings.Fields(line), columns, "filename")
+	if !ok {
+		return nil, ReasonUnknownLine
+	}
+	f := &OpenFile{}
+	targets := map[string]*uint64{
+		"tree id":        &f.TreeID,
+		"ses id":         &f.SessionID,
+		"persistent fid": &f.PersistentFID,
+		"flags":          &f.Flags,
+		"count":          &f.Count,
+		"pid":            &f.PID,
+		"uid":            &f.UID,
+	}
+	for i, c := range columns {
+		if c == "filename" {
+			f.Filename = values[i]
+			continue
+		}
+		t, ok := targets[c]
+		if !ok {
+			continue
+		}
+		v, err := parseUint(values[i])
+		if err != nil {
+			return nil, ReasonInvalidValue
+		}
+		*t = v
+	}
+	return f, ""
+}
+
+// formatColumns returns the columns of a format header like "# <tree id> <ses id> <filename>".
+func formatColumns(line string) []string {
+	var columns []string
+	for _, m := range formatColumn.FindAllStringSubmatch(line, -1) {
+		columns = append(columns, m[1])
+	}
+	return columns
+}
+
+// splitColumns returns the values of columns in fields. The column named variable may contain
+// spaces or be empty, so we take the columns in front of it from the start and the columns after
+// it from the end. Without a variable column, fields must match the columns exactly.
+// It returns false if the number of fields does not fit.
+func splitColumns(fields []string, columns []string, variable string) ([]string, bool) {
+	v := -1
+	for i, c := range columns {
+		if c == variable {
+			v = i
+			break
+		}
+	}
+	if v < 0 {
+		return fields, len(fields) == len(columns)
+	}
+	if len(fields) < len(columns)-1 {
+		return nil, false
+	}
+	values := make([]string, len(columns))
+	after := len(columns) - v - 1
+	copy(values, fields[:v])
+	copy(values[v+1:], fields[len(fields)-after:])
+	values[v] = strings.Join(fields[v:len(fields)-after], " ")
+	return values, true
+}
@@ -0,0 +1,114 @@
+package cifs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOpenFilesExample(t *testing.T) {
+	files, err := ParseOpenFiles(openExample(t, "example2_open_files.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files.Warnings) != 0 {
+		t.Errorf("unexpected warnings: %v", files.Warnings)
+	}
+	columns := []string{"tree id", "ses id", "persistent fid", "flags", "count", "pid", "uid", "filename", "mid"}
+	if !reflect.DeepEqual(files.Columns, columns) {
+		t.Errorf("columns = %q, want %q", files.Columns, columns)
+	}
+	want := []OpenFile{
+		{TreeID: 5, SessionID: 0x6c0e2a1c00000009, PersistentFID: 0x2a1c0004, Flags: 0x8002, Count: 1, PID: 4711, UID: 0, Filename: "backup.tar"},
+		{TreeID: 5, SessionID: 0x6c0e2a1c00000009, PersistentFID: 0x2a1c0008, Flags: 0x8000, Count: 1, PID: 4711, UID: 0, Filename: "backup index.db"},
+		{TreeID: 5, SessionID: 0x6c0e2a1c00000009, PersistentFID: 0x2a1c000c, Flags: 0x8000, Count: 2, PID: 5120, UID: 0, Filename: "backup.tar"},
+		{TreeID: 0xd, SessionID: 0x4400000000a1, PersistentFID: 0x11f2, Flags: 0x8001, Count: 1, PID: 2048, UID: 1000, Filename: "Quarterly Report.xlsx"},
+		{TreeID: 0xd, SessionID: 0x4400000000a1, PersistentFID: 0x11f6, Flags: 0x18000, Count: 1, PID: 2048, UID: 1000, Filename: "notes"},
+	}
+	var got []OpenFile
+	for _, f := range files.Files {
+		got = append(got, *f)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseOpenFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		files    []OpenFile
+		warnings []string
+	}{
+		{
+			name: "without session",
+			input: "# Version:1\n# Format:\n# <tree id> <persistent fid> <flags> <count> <pid> <uid> <filename>\n" +
+				"0x5 0x3d4a 0x8000 1 1234 0 a file\n",
+			files: []OpenFile{{TreeID: 5, PersistentFID: 0x3d4a, Flags: 0x8000, Count: 1, PID: 1234, Filename: "a file"}},
+		},
+		{
+			name:  "without header",
+			input: "0x5 0x3d4a 0x8000 1 1234 0 b\n",
+			files: []OpenFile{{TreeID: 5, PersistentFID: 0x3d4a, Flags: 0x8000, Count: 1, PID: 1234, Filename: "b"}},
+		},
+		{
+			name: "unknown column",
+			input: "# Format:\n# <tree id> <new column> <filename>\n" +
+				"0x5 whatever c\n",
+			files: []OpenFile{{TreeID: 5, Filename: "c"}},
+		},
+		{
+			name: "invalid lines",
+			input: "# Format:\n# <tree id> <persistent fid> <flags> <count> <pid> <uid> <filename>\n" +
+				"broken\n0xzz 0x3d4b 0x8000 1 1234 0 b\n0x1 0x3d4c 0x8000 1 1234 0 c\n",
+			files:    []OpenFile{{TreeID: 1, PersistentFID: 0x3d4c, Flags: 0x8000, Count: 1, PID: 1234, Filename: "c"}},
+			warnings: []string{ReasonUnknownLine, ReasonInvalidValue},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files, err := ParseOpenFiles(strings.NewReader(test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []OpenFile
+			for _, f := range files.Files {
+				got = append(got, *f)
+			}
+			if !reflect.DeepEqual(got, test.files) {
+				t.Errorf("files = %+v, want %+v", got, test.files)
+			}
+			var reasons []string
+			for _, w := range files.Warnings {
+				reasons = append(reasons, w.Reason)
+			}
+			if !reflect.DeepEqual(reasons, test.warnings) {
+				t.Errorf("warnings = %v, want %v", reasons, test.warnings)
+			}
+		})
+	}
+}
+
+func TestSplitColumns(t *testing.T) {
+	columns := []string{"a", "name", "b"}
+	tests := []struct {
+		fields  string
+		columns []string
+		values  []string
+		ok      bool
+	}{
+		{"1 x 2", columns, []string{"1", "x", "2"}, true},
+		{"1 x y z 2", columns, []string{"1", "x y z", "2"}, true},
+		{"1 2", columns, []string{"1", "", "2"}, true},
+		{"1", columns, nil, false},
+		{"1 2", []string{"a", "b"}, []string{"1", "2"}, true},
+		{"1 2 3", []string{"a", "b"}, []string{"1", "2", "3"}, false},
+	}
+	for _, test := range tests {
+		values, ok := splitColumns(strings.Fields(test.fields), test.columns, "name")
+		if ok != test.ok || (ok && !reflect.DeepEqual(values, test.values)) {
+			t.Errorf("splitColumns(%q, %q) = %q, %v, want %q, %v", test.fields, test.columns, values, ok, test.values, test.ok)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package cifs
+
+import "errors"
+
+// ErrNotCIFS is returned by Statfs if the path is not on a CIFS mount.
+var ErrNotCIFS = errors.New("not a CIFS mount")
+
+// FSStats are the statfs values of a CIFS mount. The sizes are in bytes.
+type FSStats struct {
+	Size      uint64
+	Free      uint64
+	Avail     uint64
+	Files     uint64
+	FilesFree uint64
+}
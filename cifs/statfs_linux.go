@@ -0,0 +1,36 @@
+//go:build linux
+// +build linux
+
+package cifs
+
+import "syscall"
+
+// The file system types statfs returns for CIFS mounts.
+const (
+	cifsSuperMagic = 0xFF534D42
+	smb2SuperMagic = 0xFE534D42
+)
+
+// Statfs calls statfs on a CIFS mount. The CIFS module asks the server for every call,
+// so it blocks as long as the server does not answer. On hard mounts this may be forever.
+func Statfs(path string) (*FSStats, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return nil, err
+	}
+	// The type of the field depends on the architecture, the magic numbers are 32 bit
+	if t := uint32(st.Type); t != cifsSuperMagic && t != smb2SuperMagic {
+		return nil, ErrNotCIFS
+	}
+	size := uint64(st.Frsize)
+	if size == 0 {
+		size = uint64(st.Bsize)
+	}
+	return &FSStats{
+		Size:      st.Blocks * size,
+		Free:      st.Bfree * size,
+		Avail:     st.Bavail * size,
+		Files:     st.Files,
+		FilesFree: st.Ffree,
+	}, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package cifs
+
+// Statfs always returns ErrNotCIFS, only Linux has CIFS mounts.
+func Statfs(path string) (*FSStats, error) {
+	return nil, ErrNotCIFS
+}
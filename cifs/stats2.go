@@ -0,0 +1,144 @@
+package cifs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// smb2Commands are the SMB2 commands in the order of their command numbers.
+// Kernels with CONFIG_CIFS_STATS2 print their timings by number.
+var smb2Commands = []string{
+	"negotiate",
+	"session_setup",
+	"logoff",
+	"tree_connect",
+	"tree_disconnect",
+	"create",
+	"close",
+	"flush",
+	"read",
+	"write",
+	"lock",
+	"ioctl",
+	"cancel",
+	"echo",
+	"query_directory",
+	"change_notify",
+	"query_info",
+	"set_info",
+	"oplock_break",
+}
+
+// SMB2CommandName returns the name of a SMB2 command number, for example create for 5.
+func SMB2CommandName(command int) string {
+	if command >= 0 && command < len(smb2Commands) {
+		return smb2Commands[command]
+	}
+	return strconv.Itoa(command)
+}
+
+// ServerTimings stores the per command timings of a single connection.
+// Kernels built with CONFIG_CIFS_STATS2 print them in front of the blocks of the connection:
+//
+//	Total time spent processing by command. Time units are jiffies (250 per second)
+//	  SMB3 CMD	Number	Total Time	Fastest	Slowest
+//	  --------	------	----------	-------	-------
+//	  0		1	2		2	2
+//	  5 slow responses from server1 for command 8
+type ServerTimings struct {
+	// Server is the server of the first block following the timings. If the connection has no
+	// blocks, it is the hostname of the slow responses line and may be empty.
+	Server string
+	// HZ is the number of jiffies per second, all times are in jiffies.
+	HZ       uint64
+	Commands []CommandTiming
+}
+
+// CommandTiming stores the timings of a single SMB2 command.
+type CommandTiming struct {
+	Command int
+	Count   uint64
+	Total   uint64
+	Fastest uint64
+	Slowest uint64
+	// SlowResponses is the number of responses that took longer than the slow response threshold.
+	SlowResponses uint64
+}
+
+// Seconds converts jiffies to seconds.
+func (t *ServerTimings) Seconds(jiffies uint64) float64 {
+	if t.HZ == 0 {
+		return 0
+	}
+	return float64(jiffies) / float64(t.HZ)
+}
+
+// command returns the timing of a command number, it adds a new one if we did not see it yet.
+func (t *ServerTimings) command(command int) *CommandTiming {
+	for i := range t.Commands {
+		if t.Commands[i].Command == command {
+			return &t.Commands[i]
+		}
+	}
+	t.Commands = append(t.Commands, CommandTiming{Command: command})
+	return &t.Commands[len(t.Commands)-1]
+}
+
+// isTimingsHeader reports whether a line starts the timings of a new connection.
+func isTimingsHeader(line string) bool {
+	return strings.HasPrefix(line, "Total time spent processing by command.")
+}
+
+// parseTimingsHeader returns the new timings of the line
+// "Total time spent processing by command. Time units are jiffies (250 per second)".
+func parseTimingsHeader(line string) (*ServerTimings, string) {
+	t := &ServerTimings{}
+	i := strings.Index(line, "(")
+	if i < 0 {
+		return t, ReasonInvalidValue
+	}
+	if _, err := fmt.Sscanf(line[i:], "(%d per second)", &t.HZ); err != nil {
+		return t, ReasonInvalidValue
+	}
+	return t, ""
+}
+
+// parseLine parses a line of the timings table or a slow responses line.
+// It returns the reason if the line is neither.
+func (t *ServerTimings) parseLine(line string) string {
+	if strings.HasPrefix(line, "SMB3 CMD") || strings.HasPrefix(line, "--------") {
+		return ""
+	}
+	var slow uint64
+	var host string
+	var command int
+	if _, err := fmt.Sscanf(line, "%d slow responses from %s for command %d", &slow, &host, &command); err == nil {
+		t.command(command).SlowResponses = slow
+		if t.Server == "" {
+			t.Server = host
+		}
+		return ""
+	}
+	if strings.Contains(line, "slow responses from") {
+		return ReasonInvalidValue
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 5 {
+		return ReasonUnknownLine
+	}
+	values := make([]uint64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			if isNumber(f) {
+				return ReasonInvalidValue
+			}
+			return ReasonUnknownLine
+		}
+		values[i] = v
+	}
+	c := t.command(int(values[0]))
+	c.Count, c.Total, c.Fastest, c.Slowest = values[1], values[2], values[3], values[4]
+	return ""
+}
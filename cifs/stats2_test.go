@@ -0,0 +1,105 @@
+package cifs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseClientStatsStats2Example(t *testing.T) {
+	stats, err := ParseClientStatsStrict(openExample(t, "example3_stats2.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stats.Stats2 {
+		t.Error("Stats2 is not set")
+	}
+	if stats.Header.LargeAllocations != 148 || stats.Header.SmallAllocations != 5321 {
+		t.Errorf("allocations = %d/%d, want 148/5321", stats.Header.LargeAllocations, stats.Header.SmallAllocations)
+	}
+	if len(stats.Blocks) != 3 {
+		t.Errorf("got %d blocks, want 3", len(stats.Blocks))
+	}
+	if len(stats.Timings) != 2 {
+		t.Fatalf("got %d timings, want 2", len(stats.Timings))
+	}
+	for i, server := range []string{"10.0.0.5", "file_srv.example.com"} {
+		timings := stats.Timings[i]
+		if timings.Server != server || timings.HZ != 250 || len(timings.Commands) != 19 {
+			t.Errorf("timings %d = %s, %d HZ, %d commands, want %s, 250 HZ, 19 commands",
+				i, timings.Server, timings.HZ, len(timings.Commands), server)
+		}
+	}
+	for i, want := range []ServerInFlight{{"10.0.0.5", 3}, {"file_srv.example.com", 1}} {
+		if i >= len(stats.InFlight) || *stats.InFlight[i] != want {
+			t.Errorf("in flight %d is missing or not %+v", i, want)
+		}
+	}
+	want := CommandTiming{Command: 9, Count: 128, Total: 2260, Fastest: 1, Slowest: 4120, SlowResponses: 2}
+	if got := stats.Timings[0].Commands[9]; got != want {
+		t.Errorf("write = %+v, want %+v", got, want)
+	}
+	if got := stats.Timings[0].Seconds(2260); got != 9.04 {
+		t.Errorf("Seconds(2260) = %v, want 9.04", got)
+	}
+}
+
+func TestParseTimings(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		timings  []ServerTimings
+		warnings []string
+	}{
+		{
+			name: "server from slow responses",
+			input: "Total time spent processing by command. Time units are jiffies (100 per second)\n" +
+				"SMB3 CMD\tNumber\tTotal Time\tFastest\tSlowest\n--------\t------\t----------\t-------\t-------\n" +
+				"5\t\t3\t6\t\t1\t4\n3 slow responses from srv for command 5\n",
+			timings: []ServerTimings{{Server: "srv", HZ: 100, Commands: []CommandTiming{{5, 3, 6, 1, 4, 3}}}},
+		},
+		{
+			name: "server from the next block",
+			input: "Total time spent processing by command. Time units are jiffies (250 per second)\n" +
+				"0\t\t1\t2\t\t2\t2\n\n1) \\\\other\\share\nSMBs: 1\n",
+			timings: []ServerTimings{{Server: "other", HZ: 250, Commands: []CommandTiming{{0, 1, 2, 2, 2, 0}}}},
+		},
+		{
+			name: "invalid lines",
+			input: "Total time spent processing by command. Time units are jiffies\n" +
+				"0\t\t1\t2\n1\t\t-1\t2\t\t2\t2\nx slow responses from srv for command 1\n",
+			timings:  []ServerTimings{{}},
+			warnings: []string{ReasonInvalidValue, ReasonUnknownLine, ReasonInvalidValue, ReasonInvalidValue},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats, err := ParseClientStats(strings.NewReader(test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []ServerTimings
+			for _, timings := range stats.Timings {
+				got = append(got, *timings)
+			}
+			if !reflect.DeepEqual(got, test.timings) {
+				t.Errorf("timings = %+v, want %+v", got, test.timings)
+			}
+			var reasons []string
+			for _, w := range stats.Warnings {
+				reasons = append(reasons, w.Reason)
+			}
+			if !reflect.DeepEqual(reasons, test.warnings) {
+				t.Errorf("warnings = %v, want %v", reasons, test.warnings)
+			}
+		})
+	}
+}
+
+func TestSMB2CommandName(t *testing.T) {
+	for command, want := range map[int]string{0: "negotiate", 5: "create", 18: "oplock_break", 19: "19", -1: "-1"} {
+		if got := SMB2CommandName(command); got != want {
+			t.Errorf("SMB2CommandName(%d) = %q, want %q", command, got, want)
+		}
+	}
+}
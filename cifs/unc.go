@@ -0,0 +1,62 @@
+package cifs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UNC stores the parts of a UNC path like \\server\share\dir\subdir.
+type UNC struct {
+	// Server is a hostname, a FQDN, an IPv4 or an IPv6 address without brackets.
+	Server string
+	// Share is the share name without any separator.
+	Share string
+	// Path is the prefix path below the share with forward slashes, for example /dir/subdir.
+	// It is empty if the UNC path points to the share itself.
+	Path string
+}
+
+// ipv6LiteralSuffix is the suffix of IPv6 addresses in the Windows literal form,
+// for example fe80--1s4.ipv6-literal.net for fe80::1%4.
+const ipv6LiteralSuffix = ".ipv6-literal.net"
+
+// ParseUNC parses a UNC path. It accepts backslashes like in the Stats file (\\server\share)
+// and forward slashes like in mount sources (//server/share), even mixed.
+// IPv6 addresses may be written raw (\\fe80::1\share), in brackets (\\[fe80::1]\share) or in
+// the Windows literal form (\\fe80--1.ipv6-literal.net\share). DFS paths may have any number of
+// directories below the share, they end up in Path.
+func ParseUNC(s string) (UNC, error) {
+	p := strings.ReplaceAll(s, "/", `\`)
+	if !strings.HasPrefix(p, `\\`) {
+		return UNC{}, fmt.Errorf("invalid UNC path %q: must start with two slashes", s)
+	}
+	var parts []string
+	for _, part := range strings.Split(p[2:], `\`) {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) < 2 {
+		return UNC{}, fmt.Errorf("invalid UNC path %q: missing server or share", s)
+	}
+	u := UNC{Server: parseServer(parts[0]), Share: parts[1]}
+	if u.Server == "" {
+		return UNC{}, fmt.Errorf("invalid UNC path %q: empty server", s)
+	}
+	if len(parts) > 2 {
+		u.Path = "/" + strings.Join(parts[2:], "/")
+	}
+	return u, nil
+}
+
+// parseServer removes the brackets around IPv6 addresses and converts IPv6 literals.
+func parseServer(server string) string {
+	if strings.HasPrefix(server, "[") && strings.HasSuffix(server, "]") {
+		return server[1 : len(server)-1]
+	}
+	if strings.HasSuffix(strings.ToLower(server), ipv6LiteralSuffix) {
+		addr := server[:len(server)-len(ipv6LiteralSuffix)]
+		return strings.Replace(strings.ReplaceAll(addr, "-", ":"), "s", "%", 1)
+	}
+	return server
+}
@@ -0,0 +1,42 @@
+package cifs
+
+import "testing"
+
+func TestParseUNC(t *testing.T) {
+	tests := []struct {
+		input string
+		want  UNC
+	}{
+		{`\\10.0.0.5\data`, UNC{Server: "10.0.0.5", Share: "data"}},
+		{`\\file_srv\x`, UNC{Server: "file_srv", Share: "x"}},
+		{`\\file_srv.example.com\home`, UNC{Server: "file_srv.example.com", Share: "home"}},
+		{`\\[fe80::1]\s`, UNC{Server: "fe80::1", Share: "s"}},
+		{`\\fe80::1\s`, UNC{Server: "fe80::1", Share: "s"}},
+		{`\\fe80--1s4.ipv6-literal.net\s`, UNC{Server: "fe80::1%4", Share: "s"}},
+		{`\\2001-db8--5.IPV6-LITERAL.NET\archive`, UNC{Server: "2001:db8::5", Share: "archive"}},
+		{`\\dfs.example.com\root\team\a`, UNC{Server: "dfs.example.com", Share: "root", Path: "/team/a"}},
+		{`\\dfs\root\team\`, UNC{Server: "dfs", Share: "root", Path: "/team"}},
+		{`//file_srv.example.com/projects/team/a`, UNC{Server: "file_srv.example.com", Share: "projects", Path: "/team/a"}},
+		{`//[2001:db8::5]/archive`, UNC{Server: "2001:db8::5", Share: "archive"}},
+		{`\\srv/mixed\dir`, UNC{Server: "srv", Share: "mixed", Path: "/dir"}},
+		{`\\srv\share$`, UNC{Server: "srv", Share: "share$"}},
+	}
+	for _, test := range tests {
+		got, err := ParseUNC(test.input)
+		if err != nil {
+			t.Errorf("ParseUNC(%q): %v", test.input, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseUNC(%q) = %+v, want %+v", test.input, got, test.want)
+		}
+	}
+}
+
+func TestParseUNCInvalid(t *testing.T) {
+	for _, input := range []string{``, `server\share`, `\server\share`, `\\server`, `\\server\`, `\\[]\share`, `\\\share`} {
+		if u, err := ParseUNC(input); err == nil {
+			t.Errorf("ParseUNC(%q) = %+v, want error", input, u)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package collector
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shibumi/cifs-exporter/cifs"
+)
+
+// mountChecker calls statfs on every CIFS mount in the background, so a hanging mount never
+// blocks a scrape. Every call runs in its own goroutine. A call on a hard mount whose server is
+// gone may never return, so we don't start another call on a mount until the last one returned.
+// Scrapes only export what the goroutines found so far. The capacity of a mount is taken from
+// the same calls, it is not exported while the mount is stale.
+type mountChecker struct {
+	fs   cifs.FS
+	wake chan struct{}
+	// statfs and now are replaced by the tests
+	statfs func(path string) (*cifs.FSStats, error)
+	now    func() time.Time
+
+	mutex    sync.Mutex
+	interval time.Duration
+	timeout  time.Duration
+	rootfs   string
+	checks   map[string]*mountCheck
+
+	stale     *prometheus.Desc
+	duration  *prometheus.Desc
+	size      *prometheus.Desc
+	avail     *prometheus.Desc
+	free      *prometheus.Desc
+	files     *prometheus.Desc
+	filesFree *prometheus.Desc
+}
+
+// mountCheck is the state of a single mount point.
+type mountCheck struct {
+	// mount is nil if the mount point is not mounted anymore, but its last call still runs
+	mount    *cifs.Mount
+	running  bool
+	finished bool
+	start    time.Time
+	duration time.Duration
+	err      error
+	stats    *cifs.FSStats
+	// unreachable is set if we can't see the mount, for example because the exporter runs in a
+	// container without the host's root. We don't export anything for it.
+	unreachable bool
+}
+
+func newMountChecker(fs cifs.FS, config Config) *mountChecker {
+	labels := []string{"server", "share", "mountpoint"}
+	m := &mountChecker{
+		fs:        fs,
+		wake:      make(chan struct{}, 1),
+		statfs:    cifs.Statfs,
+		now:       time.Now,
+		checks:    map[string]*mountCheck{},
+		stale:     prometheus.NewDesc("cifs_mount_stale", "Boolean gauge of 1 if the last statfs on the mount failed or hangs longer than the timeout, or 0 if not", labels, nil),
+		duration:  prometheus.NewDesc("cifs_mount_check_duration_seconds", "Duration of the last statfs on the mount, or of the running one if it takes longer", labels, nil),
+		size:      prometheus.NewDesc("cifs_filesystem_size_bytes", "Size of the share in bytes", labels, nil),
+		avail:     prometheus.NewDesc("cifs_filesystem_avail_bytes", "Free space of the share available to the mounting user in bytes", labels, nil),
+		free:      prometheus.NewDesc("cifs_filesystem_free_bytes", "Free space of the share in bytes", labels, nil),
+		files:     prometheus.NewDesc("cifs_filesystem_files", "Number of file nodes of the share, 0 if the server does not tell", labels, nil),
+		filesFree: prometheus.NewDesc("cifs_filesystem_files_free", "Number of free file nodes of the share, 0 if the server does not tell", labels, nil),
+	}
+	m.setConfig(config)
+	return m
+}
+
+func (m *mountChecker) describe(ch chan<- *prometheus.Desc) {
+	ch <- m.stale
+	ch <- m.duration
+	ch <- m.size
+	ch <- m.avail
+	ch <- m.free
+	ch <- m.files
+	ch <- m.filesFree
+}
+
+// setConfig applies the check settings and starts a new round of checks.
+func (m *mountChecker) setConfig(config Config) {
+	m.mutex.Lock()
+	m.interval, m.timeout, m.rootfs = config.MountCheckInterval, config.MountCheckTimeout, config.RootFS
+	m.mutex.Unlock()
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run checks the mounts every interval, forever. An interval of 0 disables the checks.
+func (m *mountChecker) run() {
+	for {
+		m.mutex.Lock()
+		interval := m.interval
+		m.mutex.Unlock()
+		var next <-chan time.Time
+		if interval > 0 {
+			m.checkAll()
+			next = time.After(interval)
+		}
+		select {
+		case <-next:
+		case <-m.wake:
+		}
+	}
+}
+
+// checkAll starts a statfs call on every mount point without a running call.
+func (m *mountChecker) checkAll() {
+	// Reading mountinfo does not touch the mounts, so it can't hang
+	mounts, err := m.fs.Mounts()
+	if err != nil {
+		log.Printf("Could not read the CIFS mounts: %v", err)
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	seen := map[string]bool{}
+	for _, mount := range mounts {
+		// Stacked mounts show up more than once, only the top one is reachable
+		if seen[mount.MountPoint] {
+			continue
+		}
+		seen[mount.MountPoint] = true
+		check, ok := m.checks[mount.MountPoint]
+		if !ok {
+			check = &mountCheck{}
+			m.checks[mount.MountPoint] = check
+		}
+		check.mount = mount
+		if check.running {
+			continue
+		}
+		check.running, check.start = true, m.now()
+		go m.check(check, filepath.Join(m.rootfs, mount.MountPoint))
+	}
+	for mountPoint, check := range m.checks {
+		if seen[mountPoint] {
+			continue
+		}
+		if check.running {
+			check.mount = nil
+		} else {
+			delete(m.checks, mountPoint)
+		}
+	}
+}
+
+// check calls statfs on path and stores the result in check.
+func (m *mountChecker) check(check *mountCheck, path string) {
+	stats, err := m.statfs(path)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	check.running, check.finished = false, true
+	check.duration = m.now().Sub(check.start)
+	check.stats, check.err = stats, err
+	unreachable := errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) || errors.Is(err, cifs.ErrNotCIFS)
+	if unreachable && !check.unreachable {
+		log.Printf("Could not check the CIFS mount %s: %v. If the exporter runs in a container, mount the host's root and set -path.rootfs", path, err)
+	}
+	check.unreachable = unreachable
+}
+
+func (m *mountChecker) collect(ch chan<- prometheus.Metric) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.interval == 0 {
+		return
+	}
+	for mountPoint, check := range m.checks {
+		if check.mount == nil || check.unreachable {
+			continue
+		}
+		duration := check.duration
+		stale := check.finished && check.err != nil
+		if check.running {
+			running := m.now().Sub(check.start)
+			if running > m.timeout {
+				stale = true
+			}
+			if !check.finished && !stale {
+				// The first call of a new mount is still running
+				continue
+			}
+			if running > duration {
+				duration = running
+			}
+		}
+		labels := []string{check.mount.Server, check.mount.Share, mountPoint}
+		ch <- prometheus.MustNewConstMetric(m.stale, prometheus.GaugeValue, boolToFloat(stale), labels...)
+		ch <- prometheus.MustNewConstMetric(m.duration, prometheus.GaugeValue, duration.Seconds(), labels...)
+		if stale || check.stats == nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(m.size, prometheus.GaugeValue, float64(check.stats.Size), labels...)
+		ch <- prometheus.MustNewConstMetric(m.avail, prometheus.GaugeValue, float64(check.stats.Avail), labels...)
+		ch <- prometheus.MustNewConstMetric(m.free, prometheus.GaugeValue, float64(check.stats.Free), labels...)
+		ch <- prometheus.MustNewConstMetric(m.files, prometheus.GaugeValue, float64(check.stats.Files), labels...)
+		ch <- prometheus.MustNewConstMetric(m.filesFree, prometheus.GaugeValue, float64(check.stats.FilesFree), labels...)
+	}
+}
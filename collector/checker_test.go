@@ -0,0 +1,200 @@
+package collector
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shibumi/cifs-exporter/cifs"
+)
+
+// fakeStatfs counts the statfs calls by path and blocks every call until release gets a result.
+type fakeStatfs struct {
+	mutex   sync.Mutex
+	calls   map[string]int
+	started chan string
+	release chan error
+}
+
+func newFakeStatfs() *fakeStatfs {
+	return &fakeStatfs{calls: map[string]int{}, started: make(chan string, 10), release: make(chan error)}
+}
+
+func (f *fakeStatfs) statfs(path string) (*cifs.FSStats, error) {
+	f.mutex.Lock()
+	f.calls[path]++
+	f.mutex.Unlock()
+	f.started <- path
+	if err := <-f.release; err != nil {
+		return nil, err
+	}
+	return &cifs.FSStats{Size: 1000, Free: 400, Avail: 300, Files: 10, FilesFree: 5}, nil
+}
+
+func (f *fakeStatfs) callCount(path string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.calls[path]
+}
+
+// fakeClock is a clock the test moves forward by hand.
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) add(d time.Duration) {
+	c.mutex.Lock()
+	c.now = c.now.Add(d)
+	c.mutex.Unlock()
+}
+
+// newTestChecker returns a mountChecker for a procfs with a CIFS mount at /mnt/data.
+func newTestChecker(t *testing.T, f *fakeStatfs, clock *fakeClock) *mountChecker {
+	t.Helper()
+	proc := t.TempDir()
+	if err := os.Mkdir(filepath.Join(proc, "self"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	mountinfo := "36 29 0:45 / /mnt/data rw,relatime shared:101 - cifs //srv/data rw,vers=3.1.1\n"
+	if err := os.WriteFile(filepath.Join(proc, "self", "mountinfo"), []byte(mountinfo), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := cifs.NewFS(proc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newMountChecker(fs, Config{MountCheckInterval: time.Minute, MountCheckTimeout: 5 * time.Second, RootFS: "/host"})
+	m.statfs, m.now = f.statfs, clock.Now
+	return m
+}
+
+// waitChecked waits until the running statfs call stored its result.
+func waitChecked(t *testing.T, m *mountChecker) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		m.mutex.Lock()
+		running := m.checks["/mnt/data"].running
+		m.mutex.Unlock()
+		if !running {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("statfs did not return")
+}
+
+func TestMountCheckerHangingStatfs(t *testing.T) {
+	f := newFakeStatfs()
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	m := newTestChecker(t, f, clock)
+	collect := func() []string {
+		return collectMetrics(t, "cifs_", m.collect)
+	}
+
+	m.checkAll()
+	if path := <-f.started; path != "/host/mnt/data" {
+		t.Errorf("statfs on %s, want /host/mnt/data", path)
+	}
+	// The first call of a new mount is running, but not late yet
+	clock.add(time.Second)
+	if got := collect(); len(got) != 0 {
+		t.Errorf("metrics while the first call runs:\n%s", strings.Join(got, "\n"))
+	}
+
+	// The call hangs longer than the timeout, the mount is stale
+	clock.add(5 * time.Second)
+	want := []string{
+		`cifs_mount_check_duration_seconds{mountpoint="/mnt/data",server="srv",share="data"} 6`,
+		`cifs_mount_stale{mountpoint="/mnt/data",server="srv",share="data"} 1`,
+	}
+	if got := collect(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// No second call starts while the first one hangs
+	for i := 0; i < 3; i++ {
+		m.checkAll()
+	}
+	if n := f.callCount("/host/mnt/data"); n != 1 {
+		t.Errorf("%d statfs calls, want 1 while it hangs", n)
+	}
+
+	// The call returns, the mount is fine again and we know its capacity
+	clock.add(time.Second)
+	f.release <- nil
+	waitChecked(t, m)
+	want = []string{
+		`cifs_filesystem_avail_bytes{mountpoint="/mnt/data",server="srv",share="data"} 300`,
+		`cifs_filesystem_files_free{mountpoint="/mnt/data",server="srv",share="data"} 5`,
+		`cifs_filesystem_files{mountpoint="/mnt/data",server="srv",share="data"} 10`,
+		`cifs_filesystem_free_bytes{mountpoint="/mnt/data",server="srv",share="data"} 400`,
+		`cifs_filesystem_size_bytes{mountpoint="/mnt/data",server="srv",share="data"} 1000`,
+		`cifs_mount_check_duration_seconds{mountpoint="/mnt/data",server="srv",share="data"} 7`,
+		`cifs_mount_stale{mountpoint="/mnt/data",server="srv",share="data"} 0`,
+	}
+	if got := collect(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// The next round starts a new call. While it runs within the timeout, the last result stays.
+	m.checkAll()
+	<-f.started
+	if n := f.callCount("/host/mnt/data"); n != 2 {
+		t.Errorf("%d statfs calls, want 2", n)
+	}
+	clock.add(time.Second)
+	if got := collect(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// A failing call marks the mount as stale and hides the capacity
+	f.release <- errors.New("host is down")
+	waitChecked(t, m)
+	want = []string{
+		`cifs_mount_check_duration_seconds{mountpoint="/mnt/data",server="srv",share="data"} 1`,
+		`cifs_mount_stale{mountpoint="/mnt/data",server="srv",share="data"} 1`,
+	}
+	if got := collect(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestMountCheckerUnreachable(t *testing.T) {
+	f := newFakeStatfs()
+	m := newTestChecker(t, f, &fakeClock{now: time.Unix(1700000000, 0)})
+	m.checkAll()
+	<-f.started
+	// The mount point is not visible, for example in a container without the host's root
+	f.release <- os.ErrNotExist
+	waitChecked(t, m)
+	if got := collectMetrics(t, "cifs_", m.collect); len(got) != 0 {
+		t.Errorf("metrics of an unreachable mount:\n%s", strings.Join(got, "\n"))
+	}
+}
+
+func TestMountCheckerDisabled(t *testing.T) {
+	f := newFakeStatfs()
+	m := newTestChecker(t, f, &fakeClock{now: time.Unix(1700000000, 0)})
+	m.checkAll()
+	<-f.started
+	m.setConfig(Config{MountCheckTimeout: time.Second})
+	if got := collectMetrics(t, "cifs_", func(ch chan<- prometheus.Metric) { m.collect(ch) }); len(got) != 0 {
+		t.Errorf("metrics with disabled checks:\n%s", strings.Join(got, "\n"))
+	}
+	f.release <- nil
+	waitChecked(t, m)
+}
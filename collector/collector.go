@@ -3,130 +3,300 @@ package collector
 import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/shibumi/cifs-exporter/cifs"
+	"regexp"
+	"strconv"
 	"sync"
+	"time"
 )
 
+// Config holds the settings of the CIFSCollector.
+type Config struct {
+	// LegacyNames exports every Stats value a second time under its old gauge name,
+	// for example cifs_total_reads next to cifs_reads_total. This helps migrating dashboards.
+	LegacyNames bool
+	// Accumulate exports the counters accumulated over all resets of the Stats file,
+	// so they never go backwards. Otherwise we export the values as the kernel prints them.
+	Accumulate bool
+	// DebugData and Mounts enable the metrics from /proc/fs/cifs/DebugData and mountinfo.
+	DebugData bool
+	Mounts    bool
+	// Consumers exports the mount namespaces and cgroups using every share. This reads the
+	// mountinfo of a process in every mount namespace on each scrape.
+	Consumers bool
+	// OpenFiles exports the open file handles per share. OpenFilesByProcess and OpenFilesByFile
+	// additionally export them per process and per file, which may be a lot of series.
+	OpenFiles          bool
+	OpenFilesByProcess bool
+	OpenFilesByFile    bool
+	// OpenDirs exports the directories the kernel caches with a lease per share.
+	OpenDirs bool
+	// ShareInclude and ShareExclude select the exported shares by server/share.
+	// If ShareInclude is empty every share is included, ShareExclude wins over ShareInclude.
+	ShareInclude []*regexp.Regexp
+	ShareExclude []*regexp.Regexp
+	// LabelFilters drops every series with a label whose value does not match, by label name.
+	LabelFilters map[string]*regexp.Regexp
+	// MountCheckInterval is the interval of the statfs calls on every CIFS mount, 0 disables them.
+	// A mount is stale if its call fails or takes longer than MountCheckTimeout.
+	MountCheckInterval time.Duration
+	MountCheckTimeout  time.Duration
+	// RootFS is the path of the host's root, the mount points of mountinfo are relative to it.
+	RootFS string
+}
+
 type CIFSCollector struct {
-	metrics map[string]*prometheus.Desc
-	mutex   sync.Mutex
-	up      *prometheus.Desc
+	fs          cifs.FS
+	config      Config
+	header      []headerMetric
+	mutex       sync.Mutex
+	status      *statusMetrics
+	parseErrors *prometheus.Desc
+	shares      *shareMetrics
+	resets      *resetMetrics
+	tracker     *resetTracker
+	timings     *timingMetrics
+	debugData   *debugDataMetrics
+	mounts      *mountMetrics
+	consumers   *consumerMetrics
+	openFiles   *openFileMetrics
+	openDirs    *openDirMetrics
+	checker     *mountChecker
+	exporter    *exporterMetrics
 }
 
-// NewCIFSCollector creates a CIFSCollector
-func NewCIFSCollector() *CIFSCollector {
+// headerMetric describes a single value of the Stats header.
+type headerMetric struct {
+	desc      *prometheus.Desc
+	legacy    *prometheus.Desc
+	valueType prometheus.ValueType
+	value     func(h *cifs.Header) uint64
+}
+
+// parseErrorReasons are all reasons we export, so the parse error series don't come and go.
+var parseErrorReasons = []string{cifs.ReasonUnknownLine, cifs.ReasonInvalidValue, cifs.ReasonOutsideBlock, cifs.ReasonInvalidBlockHeader}
+
+// NewCIFSCollector creates a CIFSCollector, which reads all CIFS files from fs
+func NewCIFSCollector(fs cifs.FS, config Config) *CIFSCollector {
 	return &CIFSCollector{
-		metrics: map[string]*prometheus.Desc{
-			"cifs_total_cifs_sessions":        prometheus.NewDesc("cifs_total_cifs_sessions", "Total CIFS sessions", nil, nil),
-			"cifs_total_unique_mount_targets": prometheus.NewDesc("cifs_total_unique_mount_targets", "Total unique mount targets", nil, nil),
-			"cifs_total_requests":             prometheus.NewDesc("cifs_total_requests", "Total requests", nil, nil),
-			"cifs_total_buffer":               prometheus.NewDesc("cifs_total_buffer", "Total buffer", nil, nil),
-			"cifs_total_small_requests":       prometheus.NewDesc("cifs_total_small_requests", "Total small requests", nil, nil),
-			"cifs_total_small_buffer":         prometheus.NewDesc("cifs_total_small_buffer", "Total small buffer", nil, nil),
-			"cifs_total_op":                   prometheus.NewDesc("cifs_total_op", "Total op", nil, nil),
-			"cifs_total_session":              prometheus.NewDesc("cifs_total_session", "Total session", nil, nil),
-			"cifs_total_share_reconnects":     prometheus.NewDesc("cifs_total_share_reconnects", "Total share reconnects", nil, nil),
-			"cifs_total_max_op":               prometheus.NewDesc("cifs_total_max_op", "Total max op", nil, nil),
-			"cifs_total_at_once":              prometheus.NewDesc("cifs_total_at_once", "Total operations at once", nil, nil),
+		fs:     fs,
+		config: config,
+		header: []headerMetric{
+			{
+				prometheus.NewDesc("cifs_sessions", "Number of CIFS sessions in use", nil, nil),
+				prometheus.NewDesc("cifs_total_cifs_sessions", "Total CIFS sessions", nil, nil),
+				prometheus.GaugeValue, func(h *cifs.Header) uint64 { return h.CIFSSession },
+			},
+			{
+				prometheus.NewDesc("cifs_unique_mount_targets", "Number of unique mount targets in use", nil, nil),
+				prometheus.NewDesc("cifs_total_unique_mount_targets", "Total unique mount targets", nil, nil),
+				prometheus.GaugeValue, func(h *cifs.Header) uint64 { return h.Targets },
+			},
+			{
+				prometheus.NewDesc("cifs_smb_buffers", "Number of SMB request/response buffers in use", nil, nil),
+				prometheus.NewDesc("cifs_total_requests", "Total requests", nil, nil),
+				prometheus.GaugeValue, func(h *cifs.Header) uint64 { return h.SMBReq },
+			},
+			{
+				prometheus.NewDesc("cifs_smb_buffer_pool_size", "Size of the SMB request/response buffer pool", nil, nil),
+				prometheus.NewDesc("cifs_total_buffer", "Total buffer", nil, nil),
+				prometheus.GaugeValue, func(h *cifs.Header) uint64 { return h.SMBBuf },
+			},
+			{
+				prometheus.NewDesc("cifs_smb_small_buffers", "Number of small SMB request/response buffers in use", nil, nil),
+				prometheus.NewDesc("cifs_total_small_requests", "Total small requests", nil, nil),
+				prometheus.GaugeValue, func(h *cifs.Header) uint64 { return h.SMBSmallReq },
+			},
+			{
+				prometheus.NewDesc("cifs_smb_small_buffer_pool_size", "Size of the small SMB request/response buffer pool", nil, nil),
+				prometheus.NewDesc("cifs_total_small_buffer", "Total small buffer", nil, nil),
+				prometheus.GaugeValue, func(h *cifs.Header) uint64 { return h.SMBSmallBuf },
+			},
+			{
+				prometheus.NewDesc("cifs_mids", "Number of operations (MIDs) in use", nil, nil),
+				prometheus.NewDesc("cifs_total_op", "Total op", nil, nil),
+				prometheus.GaugeValue, func(h *cifs.Header) uint64 { return h.Op },
+			},
+			{
+				prometheus.NewDesc("cifs_session_reconnects_total", "Number of session reconnects", nil, nil),
+				prometheus.NewDesc("cifs_total_session", "Total session", nil, nil),
+				prometheus.CounterValue, func(h *cifs.Header) uint64 { return h.Session },
+			},
+			{
+				prometheus.NewDesc("cifs_share_reconnects_total", "Number of share reconnects", nil, nil),
+				prometheus.NewDesc("cifs_total_share_reconnects", "Total share reconnects", nil, nil),
+				prometheus.CounterValue, func(h *cifs.Header) uint64 { return h.ShareReconnects },
+			},
+			{
+				prometheus.NewDesc("cifs_vfs_operations_total", "Number of VFS operations", nil, nil),
+				prometheus.NewDesc("cifs_total_max_op", "Total max op", nil, nil),
+				prometheus.CounterValue, func(h *cifs.Header) uint64 { return h.MaxOp },
+			},
+			{
+				prometheus.NewDesc("cifs_vfs_operations_max_at_once", "Maximum number of VFS operations at one time", nil, nil),
+				prometheus.NewDesc("cifs_total_at_once", "Total operations at once", nil, nil),
+				prometheus.GaugeValue, func(h *cifs.Header) uint64 { return h.AtOnce },
+			},
 		},
-		up: prometheus.NewDesc("cifs_up", "Boolean gauge of 1 if cifs shares are available, or 0 if not", nil, nil),
+		status:      newStatusMetrics(),
+		parseErrors: prometheus.NewDesc("cifs_stats_parse_errors", "Number of lines in the CIFS statistics that could not be parsed during the last scrape", []string{"reason"}, nil),
+		shares:      newShareMetrics(),
+		resets:      newResetMetrics(),
+		tracker:     newResetTracker(),
+		timings:     newTimingMetrics(),
+		debugData:   newDebugDataMetrics(),
+		mounts:      newMountMetrics(),
+		consumers:   newConsumerMetrics(),
+		openFiles:   newOpenFileMetrics(),
+		openDirs:    newOpenDirMetrics(),
+		checker:     newMountChecker(fs, config),
+		exporter:    newExporterMetrics(),
 	}
 }
 
 // Describe outputs metrics descriptions.
 func (c *CIFSCollector) Describe(ch chan<- *prometheus.Desc) {
-	for _, m := range c.metrics {
-		ch <- m
+	for _, m := range c.header {
+		ch <- m.desc
+		ch <- m.legacy
 	}
+	ch <- c.parseErrors
+	c.status.describe(ch)
+	c.shares.describe(ch)
+	c.resets.describe(ch)
+	c.timings.describe(ch)
+	c.debugData.describe(ch)
+	c.mounts.describe(ch)
+	c.consumers.describe(ch)
+	c.openFiles.describe(ch)
+	c.openDirs.describe(ch)
+	c.checker.describe(ch)
+	c.exporter.describe(ch)
+}
+
+// SetConfig replaces the settings of the collector, it takes effect with the next scrape.
+// Everything the collector remembers, like the values for the reset detection, is kept.
+func (c *CIFSCollector) SetConfig(config Config) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.config = config
+	c.checker.setConfig(config)
 }
 
+// CheckMounts checks the CIFS mounts in the background for hanging calls, it never returns.
+func (c *CIFSCollector) CheckMounts() {
+	c.checker.run()
+}
+
+// Collect reads the CIFS files and exports all metrics, which pass the share and label filters.
 func (c *CIFSCollector) Collect(ch chan<- prometheus.Metric) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	stats, err := cifs.NewClientStats()
-	if err != nil {
-		ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, float64(0))
+	f := newFilter(c.config)
+	if f.empty() {
+		c.collect(ch)
 		return
 	}
-	ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, float64(1))
-	ch <- prometheus.MustNewConstMetric(c.metrics["cifs_total_cifs_sessions"], prometheus.GaugeValue, float64(stats.Header.CIFSSession))
-	ch <- prometheus.MustNewConstMetric(c.metrics["cifs_total_unique_mount_targets"], prometheus.GaugeValue, float64(stats.Header.Targets))
-	ch <- prometheus.MustNewConstMetric(c.metrics["cifs_total_requests"], prometheus.GaugeValue, float64(stats.Header.SMBReq))
-	ch <- prometheus.MustNewConstMetric(c.metrics["cifs_total_buffer"], prometheus.GaugeValue, float64(stats.Header.SMBBuf))
-	ch <- prometheus.MustNewConstMetric(c.metrics["cifs_total_small_requests"], prometheus.GaugeValue, float64(stats.Header.SMBSmallReq))
-	ch <- prometheus.MustNewConstMetric(c.metrics["cifs_total_small_buffer"], prometheus.GaugeValue, float64(stats.Header.SMBSmallBuf))
-	ch <- prometheus.MustNewConstMetric(c.metrics["cifs_total_op"], prometheus.GaugeValue, float64(stats.Header.Op))
-	ch <- prometheus.MustNewConstMetric(c.metrics["cifs_total_session"], prometheus.GaugeValue, float64(stats.Header.Session))
-	ch <- prometheus.MustNewConstMetric(c.metrics["cifs_total_share_reconnects"], prometheus.GaugeValue, float64(stats.Header.ShareReconnects))
-	ch <- prometheus.MustNewConstMetric(c.metrics["cifs_total_max_op"], prometheus.GaugeValue, float64(stats.Header.MaxOp))
-	ch <- prometheus.MustNewConstMetric(c.metrics["cifs_total_at_once"], prometheus.GaugeValue, float64(stats.Header.AtOnce))
+	filtered := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		for m := range filtered {
+			if f.keep(m) {
+				ch <- m
+			}
+		}
+		close(done)
+	}()
+	c.collect(filtered)
+	close(filtered)
+	<-done
+}
 
-	// len(SMB1/2 metrics) = 22
-	// len(SMB3 metrics) = 39
-	for _, block := range stats.Blocks {
-		l := prometheus.Labels{"server": block.Server, "share": block.Share}
-		if len(block.Metrics) == 22 {
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_smb", "Total SMB", nil, l), prometheus.GaugeValue, float64(block.Metrics[0]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_oplocks", "Total oplock breaks", nil, l), prometheus.GaugeValue, float64(block.Metrics[1]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_reads", "Total reads", nil, l), prometheus.GaugeValue, float64(block.Metrics[2]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_read_bytes", "Total read bytes", nil, l), prometheus.GaugeValue, float64(block.Metrics[3]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_writes", "Total writes", nil, l), prometheus.GaugeValue, float64(block.Metrics[4]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_write_bytes", "Total write bytes", nil, l), prometheus.GaugeValue, float64(block.Metrics[5]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_flushes", "Total flushes", nil, l), prometheus.GaugeValue, float64(block.Metrics[6]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_locks", "Total locks", nil, l), prometheus.GaugeValue, float64(block.Metrics[7]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_hardlinks", "Total hardlinks", nil, l), prometheus.GaugeValue, float64(block.Metrics[8]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_symlinks", "Total symlinks", nil, l), prometheus.GaugeValue, float64(block.Metrics[9]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_opens", "Total opens", nil, l), prometheus.GaugeValue, float64(block.Metrics[10]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_closes", "Total closes", nil, l), prometheus.GaugeValue, float64(block.Metrics[11]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_deletes", "Total deletes", nil, l), prometheus.GaugeValue, float64(block.Metrics[12]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_posix_opens", "Total posix opens", nil, l), prometheus.GaugeValue, float64(block.Metrics[13]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_posix_mkdirs", "Total posix mkdirs", nil, l), prometheus.GaugeValue, float64(block.Metrics[14]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_mkdirs", "Total mkdirs", nil, l), prometheus.GaugeValue, float64(block.Metrics[15]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_rmdirs", "Total rmdirs", nil, l), prometheus.GaugeValue, float64(block.Metrics[16]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_renames", "Total renames", nil, l), prometheus.GaugeValue, float64(block.Metrics[17]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_t2_renames", "Total T2 renames", nil, l), prometheus.GaugeValue, float64(block.Metrics[18]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_find_first", "Total find first", nil, l), prometheus.GaugeValue, float64(block.Metrics[19]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_find_next", "Total find next", nil, l), prometheus.GaugeValue, float64(block.Metrics[20]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_find_close", "Total find close", nil, l), prometheus.GaugeValue, float64(block.Metrics[21]))
-		} else if len(block.Metrics) == 39 {
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_smb", "Total SMB", nil, l), prometheus.GaugeValue, float64(block.Metrics[0]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_negotiates_sent", "Total negotiates sent", nil, l), prometheus.GaugeValue, float64(block.Metrics[1]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_negotiates_failed", "Total negotiates failed", nil, l), prometheus.GaugeValue, float64(block.Metrics[2]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_session_setups_sent", "Total session setups sent", nil, l), prometheus.GaugeValue, float64(block.Metrics[3]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_session_setups_failed", "Total session setups fauled", nil, l), prometheus.GaugeValue, float64(block.Metrics[4]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_logoffs_sent", "Total logoffs sent", nil, l), prometheus.GaugeValue, float64(block.Metrics[5]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_logoffs_failed", "Total logoffs failed", nil, l), prometheus.GaugeValue, float64(block.Metrics[6]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_tree_connects_sent", "Total tree_connects sent", nil, l), prometheus.GaugeValue, float64(block.Metrics[7]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_tree_connects_failed", "Total tree_connects failed", nil, l), prometheus.GaugeValue, float64(block.Metrics[8]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_tree_disconnects_sent", "Total tree_disconnects sent", nil, l), prometheus.GaugeValue, float64(block.Metrics[9]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_tree_disconnects_failed", "Total tree_disconnects failed", nil, l), prometheus.GaugeValue, float64(block.Metrics[10]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_creates_sent", "Total creates sent", nil, l), prometheus.GaugeValue, float64(block.Metrics[11]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_creates_failed", "Total creates failed", nil, l), prometheus.GaugeValue, float64(block.Metrics[12]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_closes_sent", "Total closes sent", nil, l), prometheus.GaugeValue, float64(block.Metrics[13]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_closes_failed", "Total closes failed", nil, l), prometheus.GaugeValue, float64(block.Metrics[14]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_flushes_sent", "Total flushes sent", nil, l), prometheus.GaugeValue, float64(block.Metrics[15]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_flushes_failed", "Total flushes failed", nil, l), prometheus.GaugeValue, float64(block.Metrics[16]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_reads_sent", "Total reads sent", nil, l), prometheus.GaugeValue, float64(block.Metrics[17]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_reads_failed", "Total reads failed", nil, l), prometheus.GaugeValue, float64(block.Metrics[18]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_writes_sent", "Total writes sent", nil, l), prometheus.GaugeValue, float64(block.Metrics[19]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_writes_failed", "Total writes failed", nil, l), prometheus.GaugeValue, float64(block.Metrics[20]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_locks_sent", "Total locks sent", nil, l), prometheus.GaugeValue, float64(block.Metrics[21]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_locks_failed", "Total locks failed", nil, l), prometheus.GaugeValue, float64(block.Metrics[22]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_ioctls_sent", "Total ioctls sent", nil, l), prometheus.GaugeValue, float64(block.Metrics[23]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_ioctls_failed", "Total ioctls failed", nil, l), prometheus.GaugeValue, float64(block.Metrics[24]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_cancels_sent", "Total cancels sent", nil, l), prometheus.GaugeValue, float64(block.Metrics[25]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_cancels_failed", "Total cancels failed", nil, l), prometheus.GaugeValue, float64(block.Metrics[26]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_echos_sent", "Total echos sent", nil, l), prometheus.GaugeValue, float64(block.Metrics[27]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_echos_failed", "Total echos failed", nil, l), prometheus.GaugeValue, float64(block.Metrics[28]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_query_directories_sent", "Total query_directories sent", nil, l), prometheus.GaugeValue, float64(block.Metrics[29]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_query_directories_failed", "Total query_directories failed", nil, l), prometheus.GaugeValue, float64(block.Metrics[30]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_change_notifies_sent", "Total change_notifies sent", nil, l), prometheus.GaugeValue, float64(block.Metrics[31]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_change_notifies_failed", "Total change_notifies failed", nil, l), prometheus.GaugeValue, float64(block.Metrics[32]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_query_infos_sent", "Total query_infos sent", nil, l), prometheus.GaugeValue, float64(block.Metrics[33]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_query_infos_failed", "Total query_infos failed", nil, l), prometheus.GaugeValue, float64(block.Metrics[34]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_set_infos_sent", "Total set_infos sent", nil, l), prometheus.GaugeValue, float64(block.Metrics[35]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_set_infos_failed", "Total set_infos failed", nil, l), prometheus.GaugeValue, float64(block.Metrics[36]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_oplocks_sent", "Total oplocks breaks sent", nil, l), prometheus.GaugeValue, float64(block.Metrics[37]))
-			ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("cifs_total_oplocks_failed", "Total oplocks breaks failed", nil, l), prometheus.GaugeValue, float64(block.Metrics[38]))
+// collect does the work of Collect, c.mutex has to be locked.
+func (c *CIFSCollector) collect(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	defer func() {
+		c.exporter.collect(ch, time.Since(start))
+	}()
+	// The checker does not touch the mounts during the scrape, so this can't hang
+	c.checker.collect(ch)
+	stats, err := c.fs.ClientStats()
+	if err != nil {
+		c.exporter.observeFailure()
+		c.status.collectFailure(ch, c.fs.ModuleLoaded(), err)
+		return
+	}
+	c.exporter.observeStats(stats)
+	// DebugData is optional, we only export it if we can read it
+	var data *cifs.DebugData
+	if c.config.DebugData {
+		if data, err = c.fs.DebugData(); err == nil {
+			c.exporter.observeWarnings(fileDebugData, data.Warnings)
+			c.debugData.collect(ch, data)
+		} else {
+			data = nil
+		}
+	}
+	c.status.collect(ch, stats.Blocks, data)
+	if c.config.Mounts {
+		if mounts, err := c.fs.Mounts(); err == nil {
+			c.mounts.collect(ch, mounts)
+		}
+	}
+	if c.config.Consumers {
+		if consumers, err := c.fs.Consumers(); err == nil {
+			c.consumers.collect(ch, consumers)
+		}
+	}
+	// open_files and open_dirs only have tree IDs, we need DebugData to map them to shares,
+	// even if its metrics are disabled
+	trees := data
+	if trees == nil && (c.config.OpenFiles || c.config.OpenDirs) {
+		trees, _ = c.fs.DebugData()
+	}
+	if c.config.OpenFiles {
+		if files, err := c.fs.OpenFiles(); err == nil {
+			c.exporter.observeWarnings(fileOpenFiles, files.Warnings)
+			c.openFiles.collect(ch, files, trees, c.config)
+		}
+	}
+	// Only kernels since 6.6 have open_dirs
+	if c.config.OpenDirs {
+		if dirs, err := c.fs.OpenDirs(); err == nil {
+			c.exporter.observeWarnings(fileOpenDirs, dirs.Warnings)
+			c.openDirs.collect(ch, dirs, trees)
+		}
+	}
+	reasons := map[string]int{}
+	for _, w := range stats.Warnings {
+		reasons[w.Reason]++
+	}
+	for _, reason := range parseErrorReasons {
+		ch <- prometheus.MustNewConstMetric(c.parseErrors, prometheus.GaugeValue, float64(reasons[reason]), reason)
+	}
+	c.tracker.begin()
+	header := map[string]uint64{}
+	for i, m := range c.header {
+		if m.valueType == prometheus.CounterValue {
+			header["header/"+strconv.Itoa(i)] = m.value(&stats.Header)
+		}
+	}
+	accumulated, reset := c.tracker.observe(counterKey{name: "header"}, []map[string]uint64{header})
+	if reset {
+		c.tracker.reset(shareKey{})
+	}
+	for i, m := range c.header {
+		v := m.value(&stats.Header)
+		exported := v
+		if m.valueType == prometheus.CounterValue && c.config.Accumulate {
+			exported = accumulated["header/"+strconv.Itoa(i)]
+		}
+		ch <- prometheus.MustNewConstMetric(m.desc, m.valueType, float64(exported))
+		if c.config.LegacyNames {
+			ch <- prometheus.MustNewConstMetric(m.legacy, prometheus.GaugeValue, float64(v))
 		}
 	}
+	c.shares.collect(ch, stats.Blocks, c.config, c.tracker)
+	c.timings.collect(ch, stats, c.config, c.tracker)
+	c.tracker.prune()
+	c.resets.collect(ch, c.tracker)
 }
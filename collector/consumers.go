@@ -0,0 +1,37 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shibumi/cifs-exporter/cifs"
+)
+
+// consumerMetrics exports which mount namespaces, and so which pods and containers, use a share.
+// It is only enabled with Config.Consumers, because it reads the mountinfo of every process.
+type consumerMetrics struct {
+	info *prometheus.Desc
+}
+
+func newConsumerMetrics() *consumerMetrics {
+	return &consumerMetrics{
+		info: prometheus.NewDesc("cifs_share_consumer_info", "Information about a mount namespace using a CIFS share, always 1",
+			[]string{"server", "share", "mountpoint", "mount_namespace", "cgroup", "pod_uid", "container_id"}, nil),
+	}
+}
+
+func (m *consumerMetrics) describe(ch chan<- *prometheus.Desc) {
+	ch <- m.info
+}
+
+func (m *consumerMetrics) collect(ch chan<- prometheus.Metric, consumers []*cifs.Consumer) {
+	seen := map[string]bool{}
+	for _, c := range consumers {
+		// Stacked mounts show up more than once in the same namespace, we only export the first one.
+		key := c.MountNamespace + "/" + c.Mount.MountPoint
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		ch <- prometheus.MustNewConstMetric(m.info, prometheus.GaugeValue, 1,
+			c.Mount.Server, c.Mount.Share, c.Mount.MountPoint, c.MountNamespace, c.Cgroup, c.PodUID, c.ContainerID)
+	}
+}
@@ -0,0 +1,103 @@
+package collector
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shibumi/cifs-exporter/cifs"
+)
+
+// debugDataMetrics exports the connection, session and share state from /proc/fs/cifs/DebugData.
+type debugDataMetrics struct {
+	connectionUp   *prometheus.Desc
+	capabilities   *prometheus.Desc
+	credits        *prometheus.Desc
+	inFlight       *prometheus.Desc
+	sessionStatus  *prometheus.Desc
+	sessionInfo    *prometheus.Desc
+	sessionChannel *prometheus.Desc
+	shareStatus    *prometheus.Desc
+}
+
+func newDebugDataMetrics() *debugDataMetrics {
+	server := []string{"server"}
+	share := []string{"server", "share"}
+	return &debugDataMetrics{
+		connectionUp:   prometheus.NewDesc("cifs_connection_up", "Boolean gauge of 1 if the TCP connection to the server is established, or 0 if not", server, nil),
+		capabilities:   prometheus.NewDesc("cifs_server_capabilities_info", "Capabilities the server announced on the connection as hex bitmask, for example 0x300067", append(server, "capabilities"), nil),
+		credits:        prometheus.NewDesc("cifs_server_credits", "Number of credits the server granted on the connection", server, nil),
+		inFlight:       prometheus.NewDesc("cifs_inflight_requests", "Number of requests on the wire waiting for a response", server, nil),
+		sessionStatus:  prometheus.NewDesc("cifs_session_status", "Kernel status of the session a share belongs to, 1 is good, everything else means new, exiting or reconnecting", share, nil),
+		sessionInfo:    prometheus.NewDesc("cifs_session_info", "Information about the session a share belongs to", append(share, "dialect", "security_type", "encrypted", "signed"), nil),
+		sessionChannel: prometheus.NewDesc("cifs_session_channels", "Number of channels of the session a share belongs to", share, nil),
+		shareStatus:    prometheus.NewDesc("cifs_share_status", "Kernel status of the tree connection, 1 is good, everything else means new, exiting or reconnecting", share, nil),
+	}
+}
+
+func (m *debugDataMetrics) describe(ch chan<- *prometheus.Desc) {
+	ch <- m.connectionUp
+	ch <- m.capabilities
+	ch <- m.credits
+	ch <- m.inFlight
+	ch <- m.sessionStatus
+	ch <- m.sessionInfo
+	ch <- m.sessionChannel
+	ch <- m.shareStatus
+}
+
+// collect exports all connections and shares. The kernel may list the same server more than once,
+// we export the first connection we see. The same share may be mounted by several sessions, for
+// example for different users. We export the session and tree connection with the worst status,
+// so a reconnecting session isn't hidden by a healthy one.
+func (m *debugDataMetrics) collect(ch chan<- prometheus.Metric, data *cifs.DebugData) {
+	type shareState struct {
+		conn    *cifs.Connection
+		session *cifs.Session
+		tree    *cifs.Tree
+	}
+	servers := map[string]bool{}
+	shares := map[shareKey]*shareState{}
+	var order []shareKey
+	for _, conn := range data.Connections {
+		if !servers[conn.Hostname] {
+			servers[conn.Hostname] = true
+			up := 0.0
+			if conn.Up() {
+				up = 1
+			}
+			ch <- prometheus.MustNewConstMetric(m.connectionUp, prometheus.GaugeValue, up, conn.Hostname)
+			ch <- prometheus.MustNewConstMetric(m.capabilities, prometheus.GaugeValue, 1, conn.Hostname, fmt.Sprintf("0x%x", conn.Capabilities))
+			ch <- prometheus.MustNewConstMetric(m.credits, prometheus.GaugeValue, float64(conn.Credits), conn.Hostname)
+			ch <- prometheus.MustNewConstMetric(m.inFlight, prometheus.GaugeValue, float64(conn.InFlight), conn.Hostname)
+		}
+		for _, session := range conn.Sessions {
+			for _, tree := range session.Shares {
+				if tree.IPC {
+					continue
+				}
+				key := shareKey{tree.Server, tree.Share}
+				s, ok := shares[key]
+				if !ok {
+					shares[key] = &shareState{conn, session, tree}
+					order = append(order, key)
+					continue
+				}
+				if s.session.Status == cifs.StatusGood && session.Status != cifs.StatusGood {
+					s.conn, s.session = conn, session
+				}
+				if s.tree.Status == cifs.StatusGood && tree.Status != cifs.StatusGood {
+					s.tree = tree
+				}
+			}
+		}
+	}
+	for _, key := range order {
+		s := shares[key]
+		ch <- prometheus.MustNewConstMetric(m.sessionStatus, prometheus.GaugeValue, float64(s.session.Status), key.server, key.share)
+		ch <- prometheus.MustNewConstMetric(m.sessionInfo, prometheus.GaugeValue, 1, key.server, key.share,
+			cifs.DialectString(s.conn.Dialect), s.session.SecurityType, strconv.FormatBool(s.session.Encrypted), strconv.FormatBool(s.session.Signed))
+		ch <- prometheus.MustNewConstMetric(m.sessionChannel, prometheus.GaugeValue, float64(1+len(s.session.Channels)), key.server, key.share)
+		ch <- prometheus.MustNewConstMetric(m.shareStatus, prometheus.GaugeValue, float64(s.tree.Status), key.server, key.share)
+	}
+}
@@ -0,0 +1,85 @@
+package collector
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/shibumi/cifs-exporter/cifs"
+)
+
+// collectMetrics runs collect and returns the sorted metrics with the given name prefix
+// as name, labels and value, for example `cifs_share_status{server="srv",share="data"} 1`.
+func collectMetrics(t *testing.T, prefix string, collect func(ch chan<- prometheus.Metric)) []string {
+	t.Helper()
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collect(ch)
+		close(ch)
+	}()
+	var res []string
+	for metric := range ch {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		// Desc has no getter for the name, it is the first quoted string of its description
+		desc := metric.Desc().String()
+		name := strings.SplitN(desc, `"`, 3)[1]
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		var labels []string
+		for _, l := range m.Label {
+			labels = append(labels, l.GetName()+`="`+l.GetValue()+`"`)
+		}
+		value := m.GetGauge().GetValue() + m.GetCounter().GetValue()
+		res = append(res, name+"{"+strings.Join(labels, ",")+"} "+strconv.FormatFloat(value, 'g', -1, 64))
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestDebugDataWorstStatus(t *testing.T) {
+	tree := func(share string, status uint64) *cifs.Tree {
+		return &cifs.Tree{Server: "srv", Share: share, Status: status}
+	}
+	data := &cifs.DebugData{Connections: []*cifs.Connection{
+		{
+			ConnectionState: cifs.ConnectionState{Status: cifs.StatusGood, Dialect: 0x311},
+			Hostname:        "srv",
+			Capabilities:    0x300067,
+			Sessions: []*cifs.Session{
+				{Status: cifs.StatusGood, SecurityType: "Kerberos", Shares: []*cifs.Tree{tree("data", 1), tree("home", 1)}},
+				// The session reconnects, but its tree connection to home is still good
+				{Status: 3, SecurityType: "RawNTLMSSP", Shares: []*cifs.Tree{tree("data", 3), tree("home", 1)}},
+				{Status: cifs.StatusGood, SecurityType: "Kerberos", Shares: []*cifs.Tree{tree("data", 1)}},
+			},
+		},
+		// The second connection to the same server is not exported
+		{Hostname: "srv", Capabilities: 0x1},
+	}}
+	m := newDebugDataMetrics()
+	got := collectMetrics(t, "cifs_", func(ch chan<- prometheus.Metric) { m.collect(ch, data) })
+	want := []string{
+		`cifs_connection_up{server="srv"} 1`,
+		`cifs_inflight_requests{server="srv"} 0`,
+		`cifs_server_capabilities_info{capabilities="0x300067",server="srv"} 1`,
+		`cifs_server_credits{server="srv"} 0`,
+		`cifs_session_channels{server="srv",share="data"} 1`,
+		`cifs_session_channels{server="srv",share="home"} 1`,
+		`cifs_session_info{dialect="3.1.1",encrypted="false",security_type="RawNTLMSSP",server="srv",share="data",signed="false"} 1`,
+		`cifs_session_info{dialect="3.1.1",encrypted="false",security_type="RawNTLMSSP",server="srv",share="home",signed="false"} 1`,
+		`cifs_session_status{server="srv",share="data"} 3`,
+		`cifs_session_status{server="srv",share="home"} 3`,
+		`cifs_share_status{server="srv",share="data"} 3`,
+		`cifs_share_status{server="srv",share="home"} 1`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
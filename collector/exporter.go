@@ -0,0 +1,113 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shibumi/cifs-exporter/cifs"
+)
+
+// The files we count parse errors for.
+const (
+	fileStats     = "Stats"
+	fileDebugData = "DebugData"
+	fileOpenFiles = "open_files"
+	fileOpenDirs  = "open_dirs"
+)
+
+// exporterMetrics exports the health of the exporter itself.
+// parseErrors counts the malformed lines of all files over the lifetime of the exporter,
+// while the cifs_stats_parse_errors gauge of the collector only shows the Stats file of the last scrape.
+// A line which stays broken is counted once and not on every scrape, so we remember the broken
+// lines of the last scrape of every file.
+type exporterMetrics struct {
+	scrapeDuration   *prometheus.Desc
+	lastSuccess      *prometheus.Desc
+	sharesDiscovered *prometheus.Desc
+	blocksSkipped    *prometheus.Desc
+	parseErrors      *prometheus.CounterVec
+
+	lastSuccessTime time.Time
+	shares          int
+	skipped         int
+	lastWarnings    map[string]map[brokenLine]int
+}
+
+// brokenLine identifies a malformed line between two scrapes. The line number is left out,
+// it changes if a line in front of it comes or goes.
+type brokenLine struct {
+	reason string
+	text   string
+}
+
+func newExporterMetrics() *exporterMetrics {
+	m := &exporterMetrics{
+		scrapeDuration:   prometheus.NewDesc("cifs_exporter_scrape_duration_seconds", "Duration of the last scrape of the CIFS files", nil, nil),
+		lastSuccess:      prometheus.NewDesc("cifs_exporter_last_successful_scrape_timestamp_seconds", "Unix timestamp of the last scrape that could read /proc/fs/cifs/Stats", nil, nil),
+		sharesDiscovered: prometheus.NewDesc("cifs_exporter_shares_discovered", "Number of distinct shares found in /proc/fs/cifs/Stats during the last scrape", nil, nil),
+		blocksSkipped:    prometheus.NewDesc("cifs_exporter_blocks_skipped", "Number of blocks in /proc/fs/cifs/Stats skipped during the last scrape", nil, nil),
+		parseErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cifs_exporter_parse_errors_total",
+			Help: "Number of lines in the CIFS files that could not be parsed, a line broken in several scrapes in a row is counted once",
+		}, []string{"file", "reason"}),
+		lastWarnings: map[string]map[brokenLine]int{},
+	}
+	// We initialize all known combinations, so the counters don't appear out of nowhere.
+	for _, reason := range parseErrorReasons {
+		m.parseErrors.WithLabelValues(fileStats, reason)
+	}
+	m.parseErrors.WithLabelValues(fileDebugData, cifs.ReasonInvalidValue)
+	m.parseErrors.WithLabelValues(fileOpenFiles, cifs.ReasonUnknownLine)
+	m.parseErrors.WithLabelValues(fileOpenFiles, cifs.ReasonInvalidValue)
+	m.parseErrors.WithLabelValues(fileOpenDirs, cifs.ReasonUnknownLine)
+	m.parseErrors.WithLabelValues(fileOpenDirs, cifs.ReasonInvalidValue)
+	return m
+}
+
+func (m *exporterMetrics) describe(ch chan<- *prometheus.Desc) {
+	ch <- m.scrapeDuration
+	ch <- m.lastSuccess
+	ch <- m.sharesDiscovered
+	ch <- m.blocksSkipped
+	m.parseErrors.Describe(ch)
+}
+
+// observeStats remembers the results of a successful read of the Stats file.
+func (m *exporterMetrics) observeStats(stats *cifs.ClientStats) {
+	m.lastSuccessTime = time.Now()
+	m.shares = len(mergeBlocks(stats.Blocks))
+	m.skipped = stats.SkippedBlocks
+	m.observeWarnings(fileStats, stats.Warnings)
+}
+
+// observeFailure resets the share counts, if we could not read the Stats file.
+func (m *exporterMetrics) observeFailure() {
+	m.shares = 0
+	m.skipped = 0
+}
+
+// observeWarnings counts the parse errors of a file, which were not in the last scrape of the file.
+// If the same line is broken several times, we only count the additional ones.
+func (m *exporterMetrics) observeWarnings(file string, warnings []cifs.LineError) {
+	last := m.lastWarnings[file]
+	current := map[brokenLine]int{}
+	for _, w := range warnings {
+		line := brokenLine{w.Reason, w.Text}
+		current[line]++
+		if current[line] > last[line] {
+			m.parseErrors.WithLabelValues(file, w.Reason).Inc()
+		}
+	}
+	m.lastWarnings[file] = current
+}
+
+// collect exports the exporter metrics. It is called at the end of every scrape.
+func (m *exporterMetrics) collect(ch chan<- prometheus.Metric, duration time.Duration) {
+	ch <- prometheus.MustNewConstMetric(m.scrapeDuration, prometheus.GaugeValue, duration.Seconds())
+	if !m.lastSuccessTime.IsZero() {
+		ch <- prometheus.MustNewConstMetric(m.lastSuccess, prometheus.GaugeValue, float64(m.lastSuccessTime.UnixNano())/1e9)
+	}
+	ch <- prometheus.MustNewConstMetric(m.sharesDiscovered, prometheus.GaugeValue, float64(m.shares))
+	ch <- prometheus.MustNewConstMetric(m.blocksSkipped, prometheus.GaugeValue, float64(m.skipped))
+	m.parseErrors.Collect(ch)
+}
@@ -0,0 +1,42 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shibumi/cifs-exporter/cifs"
+)
+
+func TestExporterParseErrors(t *testing.T) {
+	m := newExporterMetrics()
+	broken := func(line int, reason, text string) cifs.LineError {
+		return cifs.LineError{Line: line, Text: text, Reason: reason}
+	}
+	count := func(file, reason string) float64 {
+		return testutil.ToFloat64(m.parseErrors.WithLabelValues(file, reason))
+	}
+	tests := []struct {
+		name     string
+		warnings []cifs.LineError
+		want     float64
+	}{
+		{"first scrape", []cifs.LineError{broken(3, cifs.ReasonUnknownLine, "garbage")}, 1},
+		// The same line is still broken, even if it moved
+		{"still broken", []cifs.LineError{broken(5, cifs.ReasonUnknownLine, "garbage")}, 1},
+		{"second copy", []cifs.LineError{broken(5, cifs.ReasonUnknownLine, "garbage"), broken(9, cifs.ReasonUnknownLine, "garbage")}, 2},
+		{"other line", []cifs.LineError{broken(5, cifs.ReasonUnknownLine, "garbage"), broken(6, cifs.ReasonUnknownLine, "more garbage")}, 3},
+		{"fixed", nil, 3},
+		{"broken again", []cifs.LineError{broken(5, cifs.ReasonUnknownLine, "garbage")}, 4},
+	}
+	for _, test := range tests {
+		m.observeWarnings(fileOpenFiles, test.warnings)
+		if got := count(fileOpenFiles, cifs.ReasonUnknownLine); got != test.want {
+			t.Errorf("%s: got %v parse errors, want %v", test.name, got, test.want)
+		}
+	}
+	// Every file remembers its own lines
+	m.observeWarnings(fileOpenDirs, []cifs.LineError{broken(5, cifs.ReasonUnknownLine, "garbage")})
+	if got := count(fileOpenDirs, cifs.ReasonUnknownLine); got != 1 {
+		t.Errorf("got %v parse errors of open_dirs, want 1", got)
+	}
+}
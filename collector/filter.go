@@ -0,0 +1,68 @@
+package collector
+
+import (
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// filter drops the series of excluded shares and the series with labels that don't match
+// the label filters. It looks at the labels of the finished metrics, so it works for all
+// metrics the same way.
+type filter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	labels  map[string]*regexp.Regexp
+}
+
+func newFilter(config Config) filter {
+	return filter{include: config.ShareInclude, exclude: config.ShareExclude, labels: config.LabelFilters}
+}
+
+// empty reports whether the filter keeps every metric.
+func (f filter) empty() bool {
+	return len(f.include) == 0 && len(f.exclude) == 0 && len(f.labels) == 0
+}
+
+// keep reports whether a metric passes the filter. Metrics without server and share label
+// are not affected by the share patterns.
+func (f filter) keep(m prometheus.Metric) bool {
+	var pb dto.Metric
+	if err := m.Write(&pb); err != nil {
+		return true
+	}
+	labels := map[string]string{}
+	for _, l := range pb.Label {
+		labels[l.GetName()] = l.GetValue()
+	}
+	for name, re := range f.labels {
+		if v, ok := labels[name]; ok && !re.MatchString(v) {
+			return false
+		}
+	}
+	server, hasServer := labels["server"]
+	share, hasShare := labels["share"]
+	if !hasServer || !hasShare {
+		return true
+	}
+	return f.keepShare(server + "/" + share)
+}
+
+// keepShare reports whether a server/share is selected by the share patterns.
+func (f filter) keepShare(share string) bool {
+	for _, re := range f.exclude {
+		if re.MatchString(share) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(share) {
+			return true
+		}
+	}
+	return false
+}
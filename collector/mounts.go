@@ -0,0 +1,38 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shibumi/cifs-exporter/cifs"
+)
+
+// mountMetrics exports the CIFS mounts from mountinfo.
+// cifs_mount_info carries the same server and share labels as the Stats metrics,
+// so the mount point can be joined to every share metric.
+type mountMetrics struct {
+	info *prometheus.Desc
+}
+
+func newMountMetrics() *mountMetrics {
+	return &mountMetrics{
+		info: prometheus.NewDesc("cifs_mount_info", "Information about a CIFS mount, always 1",
+			[]string{"server", "share", "mountpoint", "path", "fstype", "vers", "sec", "cache"}, nil),
+	}
+}
+
+func (m *mountMetrics) describe(ch chan<- *prometheus.Desc) {
+	ch <- m.info
+}
+
+func (m *mountMetrics) collect(ch chan<- prometheus.Metric, mounts []*cifs.Mount) {
+	seen := map[string]bool{}
+	for _, mount := range mounts {
+		// The same mount point shows up more than once if mounts are stacked, we only export the first one.
+		if seen[mount.MountPoint] {
+			continue
+		}
+		seen[mount.MountPoint] = true
+		ch <- prometheus.MustNewConstMetric(m.info, prometheus.GaugeValue, 1,
+			mount.Server, mount.Share, mount.MountPoint, mount.Path, mount.FSType,
+			mount.Option("vers"), mount.Option("sec"), mount.Option("cache"))
+	}
+}
@@ -0,0 +1,62 @@
+package collector
+
+import (
+	"strings"
+	"unicode"
+)
+
+// metricNames maps Stats field names to metric names, if the generated name would be wrong.
+var metricNames = map[string]string{
+	"FNext":  "find_next",
+	"FClose": "find_close",
+	"IOCTLs": "ioctls",
+}
+
+// legacyMetricNames maps Stats field names to the names we used for the old gauge metrics.
+var legacyMetricNames = map[string]string{
+	"HardLinks":    "hardlinks",
+	"OplockBreaks": "oplocks",
+}
+
+// gaugeCounters are the Stats fields which go up and down, all other fields only go up.
+var gaugeCounters = map[string]bool{
+	"Open files":           true,
+	"Open files on server": true,
+}
+
+// metricName converts a field name from the Stats file into a metric name,
+// for example "QueryDirectories" into "query_directories" and "Posix Opens" into "posix_opens".
+func metricName(field string) string {
+	if name, ok := metricNames[field]; ok {
+		return name
+	}
+	var b strings.Builder
+	runes := []rune(field)
+	for i, r := range runes {
+		switch {
+		case r == ' ' || r == '-':
+			b.WriteRune('_')
+		case unicode.IsUpper(r):
+			if i > 0 && unicode.IsLower(runes[i-1]) {
+				b.WriteRune('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// legacyMetricName converts a field name from the Stats file into the name of the old gauge metric.
+func legacyMetricName(field string) string {
+	if name, ok := legacyMetricNames[field]; ok {
+		return name
+	}
+	return metricName(field)
+}
+
+// helpName turns a metric name back into words for the help text.
+func helpName(name string) string {
+	return strings.ReplaceAll(name, "_", " ")
+}
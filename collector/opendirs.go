@@ -0,0 +1,125 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shibumi/cifs-exporter/cifs"
+)
+
+// openDirMetrics exports the cached directories from /proc/fs/cifs/open_dirs. The kernel does not
+// tell how long a directory has been cached, so we remember when we saw it first. New directories
+// are counted, a fast growing count means the cache thrashes. Directories which come and go between
+// two scrapes are not counted, so it is a lower bound.
+type openDirMetrics struct {
+	dirs      *prometheus.Desc
+	dirents   *prometheus.Desc
+	fileInfo  *prometheus.Desc
+	unmapped  *prometheus.Desc
+	oldestAge *prometheus.Desc
+	added     *prometheus.Desc
+
+	firstSeen map[cachedDirKey]time.Time
+	addedDirs map[shareKey]uint64
+	// initialized is set after the first read, the directories cached before are not new
+	initialized bool
+}
+
+// cachedDirKey identifies a cached directory handle.
+type cachedDirKey struct {
+	treeKey
+	fid uint64
+}
+
+func newOpenDirMetrics() *openDirMetrics {
+	share := []string{"server", "share"}
+	return &openDirMetrics{
+		dirs:      prometheus.NewDesc("cifs_cached_dirs", "Number of directories of the share cached with a lease", share, nil),
+		dirents:   prometheus.NewDesc("cifs_cached_dirs_dirents_valid", "Number of cached directories of the share with a complete cached listing", share, nil),
+		fileInfo:  prometheus.NewDesc("cifs_cached_dirs_file_info_valid", "Number of cached directories of the share with cached attributes", share, nil),
+		unmapped:  prometheus.NewDesc("cifs_cached_dirs_unmapped", "Number of cached directories whose tree connection is not in DebugData or not unique", nil, nil),
+		oldestAge: prometheus.NewDesc("cifs_cached_dir_oldest_age_seconds", "Time since the exporter first saw the oldest cached directory of the share", share, nil),
+		added:     prometheus.NewDesc("cifs_cached_dirs_added_total", "Number of directories of the share the exporter saw being cached", share, nil),
+		firstSeen: map[cachedDirKey]time.Time{},
+		addedDirs: map[shareKey]uint64{},
+	}
+}
+
+func (m *openDirMetrics) describe(ch chan<- *prometheus.Desc) {
+	ch <- m.dirs
+	ch <- m.dirents
+	ch <- m.fileInfo
+	ch <- m.unmapped
+	ch <- m.oldestAge
+	ch <- m.added
+}
+
+// collect exports the cached directories of every share. Like the open handles, every share in
+// DebugData gets a series and directories we can't map to a share are only counted in
+// cifs_cached_dirs_unmapped. data may be nil.
+func (m *openDirMetrics) collect(ch chan<- prometheus.Metric, dirs *cifs.OpenDirs, data *cifs.DebugData) {
+	now := time.Now()
+	trees := newTreeShares(data)
+	type shareDirs struct {
+		dirs, dirents, fileInfo int
+		oldest                  time.Time
+	}
+	shares := map[shareKey]*shareDirs{}
+	share := func(key shareKey) *shareDirs {
+		s, ok := shares[key]
+		if !ok {
+			s = &shareDirs{}
+			shares[key] = s
+		}
+		return s
+	}
+	for _, key := range trees.shares {
+		share(key)
+	}
+	seen := map[cachedDirKey]bool{}
+	unmapped := 0
+	for _, d := range dirs.Dirs {
+		key, ok := trees.lookup(d.SessionID, d.TreeID)
+		if !ok {
+			unmapped++
+			continue
+		}
+		s := share(key)
+		s.dirs++
+		if d.DirentsValid {
+			s.dirents++
+		}
+		if d.FileInfoValid {
+			s.fileInfo++
+		}
+		dir := cachedDirKey{treeKey{d.SessionID, d.TreeID}, d.PersistentFID}
+		seen[dir] = true
+		first, ok := m.firstSeen[dir]
+		if !ok {
+			first = now
+			m.firstSeen[dir] = now
+			if m.initialized {
+				m.addedDirs[key]++
+			}
+		}
+		if s.oldest.IsZero() || first.Before(s.oldest) {
+			s.oldest = first
+		}
+	}
+	for dir := range m.firstSeen {
+		if !seen[dir] {
+			delete(m.firstSeen, dir)
+		}
+	}
+	m.initialized = true
+	ch <- prometheus.MustNewConstMetric(m.unmapped, prometheus.GaugeValue, float64(unmapped))
+	for key, s := range shares {
+		ch <- prometheus.MustNewConstMetric(m.dirs, prometheus.GaugeValue, float64(s.dirs), key.server, key.share)
+		ch <- prometheus.MustNewConstMetric(m.dirents, prometheus.GaugeValue, float64(s.dirents), key.server, key.share)
+		ch <- prometheus.MustNewConstMetric(m.fileInfo, prometheus.GaugeValue, float64(s.fileInfo), key.server, key.share)
+		if !s.oldest.IsZero() {
+			ch <- prometheus.MustNewConstMetric(m.oldestAge, prometheus.GaugeValue, now.Sub(s.oldest).Seconds(), key.server, key.share)
+		}
+		ch <- prometheus.MustNewConstMetric(m.added, prometheus.CounterValue, float64(m.addedDirs[key]), key.server, key.share)
+	}
+}
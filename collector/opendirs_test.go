@@ -0,0 +1,53 @@
+package collector
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shibumi/cifs-exporter/cifs"
+)
+
+func TestOpenDirMetrics(t *testing.T) {
+	dir := func(session, fid uint64) *cifs.CachedDir {
+		return &cifs.CachedDir{SessionID: session, TreeID: 5, PersistentFID: fid, FileInfoValid: true, DirentsValid: fid%2 == 0}
+	}
+	m := newOpenDirMetrics()
+	collect := func(dirs ...*cifs.CachedDir) []string {
+		return collectMetrics(t, "cifs_cached_dirs", func(ch chan<- prometheus.Metric) {
+			m.collect(ch, &cifs.OpenDirs{Dirs: dirs}, testTrees)
+		})
+	}
+
+	// The directories cached before the first scrape are not counted as added
+	collect(dir(1, 1), dir(1, 2))
+	got := collect(dir(1, 2), dir(1, 4), dir(2, 6), dir(3, 8))
+	want := []string{
+		`cifs_cached_dirs_added_total{server="srv",share="data"} 1`,
+		`cifs_cached_dirs_added_total{server="srv",share="empty"} 0`,
+		`cifs_cached_dirs_added_total{server="srv",share="home"} 0`,
+		`cifs_cached_dirs_added_total{server="srv",share="projects"} 1`,
+		`cifs_cached_dirs_dirents_valid{server="srv",share="data"} 2`,
+		`cifs_cached_dirs_dirents_valid{server="srv",share="empty"} 0`,
+		`cifs_cached_dirs_dirents_valid{server="srv",share="home"} 0`,
+		`cifs_cached_dirs_dirents_valid{server="srv",share="projects"} 1`,
+		`cifs_cached_dirs_file_info_valid{server="srv",share="data"} 2`,
+		`cifs_cached_dirs_file_info_valid{server="srv",share="empty"} 0`,
+		`cifs_cached_dirs_file_info_valid{server="srv",share="home"} 0`,
+		`cifs_cached_dirs_file_info_valid{server="srv",share="projects"} 1`,
+		`cifs_cached_dirs_unmapped{} 1`,
+		`cifs_cached_dirs{server="srv",share="data"} 2`,
+		`cifs_cached_dirs{server="srv",share="empty"} 0`,
+		`cifs_cached_dirs{server="srv",share="home"} 0`,
+		`cifs_cached_dirs{server="srv",share="projects"} 1`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	// Directory 1 was dropped from the cache, it is new if it comes back
+	collect(dir(1, 1))
+	if n := m.addedDirs[shareKey{"srv", "data"}]; n != 2 {
+		t.Errorf("added dirs = %d, want 2", n)
+	}
+}
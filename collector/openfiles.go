@@ -0,0 +1,147 @@
+package collector
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shibumi/cifs-exporter/cifs"
+)
+
+// openFileMetrics exports the open file handles from /proc/fs/cifs/open_files.
+// The file only has the tree ID of every handle, we take the share of the tree from DebugData.
+// The handles per process and per file are only exported with Config.OpenFilesByProcess and
+// Config.OpenFilesByFile, because they have a lot of series.
+type openFileMetrics struct {
+	handles   *prometheus.Desc
+	unmapped  *prometheus.Desc
+	byProcess *prometheus.Desc
+	byFile    *prometheus.Desc
+}
+
+func newOpenFileMetrics() *openFileMetrics {
+	share := []string{"server", "share"}
+	process := append(share, "pid", "uid")
+	return &openFileMetrics{
+		handles:   prometheus.NewDesc("cifs_open_handles", "Number of open file handles on the share", share, nil),
+		unmapped:  prometheus.NewDesc("cifs_open_handles_unmapped", "Number of open file handles whose tree connection is not in DebugData or not unique", nil, nil),
+		byProcess: prometheus.NewDesc("cifs_open_handles_by_process", "Number of open file handles on the share by process and user", process, nil),
+		byFile:    prometheus.NewDesc("cifs_open_handles_by_file", "Number of open file handles on the share by process, user and filename", append(process, "filename"), nil),
+	}
+}
+
+func (m *openFileMetrics) describe(ch chan<- *prometheus.Desc) {
+	ch <- m.handles
+	ch <- m.unmapped
+	ch <- m.byProcess
+	ch <- m.byFile
+}
+
+// treeKey identifies a tree connection, tree IDs are only unique per session.
+type treeKey struct {
+	session uint64
+	tree    uint64
+}
+
+// treeShares maps the tree connections of DebugData to their shares. open_files and open_dirs
+// only tell the tree ID of every handle.
+type treeShares struct {
+	trees map[treeKey]shareKey
+	// Older kernels don't print the session of a handle, we can only use tree IDs which are
+	// unique over all sessions then
+	byTreeID  map[uint64]shareKey
+	ambiguous map[uint64]bool
+	// shares are all shares of DebugData without IPC$
+	shares []shareKey
+}
+
+// newTreeShares returns the shares of all tree connections of data, which may be nil.
+func newTreeShares(data *cifs.DebugData) *treeShares {
+	t := &treeShares{trees: map[treeKey]shareKey{}, byTreeID: map[uint64]shareKey{}, ambiguous: map[uint64]bool{}}
+	if data == nil {
+		return t
+	}
+	seen := map[shareKey]bool{}
+	for _, conn := range data.Connections {
+		for _, session := range conn.Sessions {
+			for _, tree := range session.Shares {
+				if tree.IPC {
+					continue
+				}
+				key := shareKey{tree.Server, tree.Share}
+				t.trees[treeKey{session.SessionID, tree.TreeID}] = key
+				if k, ok := t.byTreeID[tree.TreeID]; ok && k != key {
+					t.ambiguous[tree.TreeID] = true
+				}
+				t.byTreeID[tree.TreeID] = key
+				if !seen[key] {
+					seen[key] = true
+					t.shares = append(t.shares, key)
+				}
+			}
+		}
+	}
+	return t
+}
+
+// lookup returns the share of a tree connection. session is 0 if the kernel does not print it.
+// ok is false if we don't know the tree connection.
+func (t *treeShares) lookup(session, tree uint64) (key shareKey, ok bool) {
+	if session != 0 {
+		key, ok = t.trees[treeKey{session, tree}]
+		return key, ok
+	}
+	if t.ambiguous[tree] {
+		return shareKey{}, false
+	}
+	key, ok = t.byTreeID[tree]
+	return key, ok
+}
+
+// collect exports the handles of every share. Every share in DebugData gets a series, even
+// without open handles, so the series don't come and go. Handles we can't map to a share are
+// only counted in cifs_open_handles_unmapped. data may be nil, then all handles are unmapped.
+func (m *openFileMetrics) collect(ch chan<- prometheus.Metric, files *cifs.OpenFiles, data *cifs.DebugData, config Config) {
+	trees := newTreeShares(data)
+	handles := map[shareKey]int{}
+	for _, key := range trees.shares {
+		handles[key] = 0
+	}
+	type processKey struct {
+		shareKey
+		pid, uid uint64
+	}
+	type fileKey struct {
+		processKey
+		filename string
+	}
+	byProcess := map[processKey]int{}
+	byFile := map[fileKey]int{}
+	unmapped := 0
+	for _, f := range files.Files {
+		key, ok := trees.lookup(f.SessionID, f.TreeID)
+		if !ok {
+			unmapped++
+			continue
+		}
+		handles[key]++
+		process := processKey{key, f.PID, f.UID}
+		byProcess[process]++
+		byFile[fileKey{process, f.Filename}]++
+	}
+	for key, n := range handles {
+		ch <- prometheus.MustNewConstMetric(m.handles, prometheus.GaugeValue, float64(n), key.server, key.share)
+	}
+	ch <- prometheus.MustNewConstMetric(m.unmapped, prometheus.GaugeValue, float64(unmapped))
+	if config.OpenFilesByProcess {
+		for key, n := range byProcess {
+			ch <- prometheus.MustNewConstMetric(m.byProcess, prometheus.GaugeValue, float64(n),
+				key.server, key.share, strconv.FormatUint(key.pid, 10), strconv.FormatUint(key.uid, 10))
+		}
+	}
+	if config.OpenFilesByFile {
+		for key, n := range byFile {
+			ch <- prometheus.MustNewConstMetric(m.byFile, prometheus.GaugeValue, float64(n),
+				key.server, key.share, strconv.FormatUint(key.pid, 10), strconv.FormatUint(key.uid, 10), key.filename)
+		}
+	}
+}
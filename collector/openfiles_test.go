@@ -0,0 +1,89 @@
+package collector
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shibumi/cifs-exporter/cifs"
+)
+
+// testTrees has two sessions which both use tree ID 5 for different shares.
+var testTrees = &cifs.DebugData{Connections: []*cifs.Connection{{
+	Sessions: []*cifs.Session{
+		{SessionID: 1, Shares: []*cifs.Tree{
+			{Server: "srv", Share: "IPC$", IPC: true, TreeID: 1},
+			{Server: "srv", Share: "data", TreeID: 5},
+			{Server: "srv", Share: "home", TreeID: 7},
+		}},
+		{SessionID: 2, Shares: []*cifs.Tree{
+			{Server: "srv", Share: "projects", TreeID: 5},
+			{Server: "srv", Share: "empty", TreeID: 9},
+		}},
+	},
+}}}
+
+func TestTreeSharesLookup(t *testing.T) {
+	trees := newTreeShares(testTrees)
+	tests := []struct {
+		session, tree uint64
+		want          shareKey
+		ok            bool
+	}{
+		{1, 5, shareKey{"srv", "data"}, true},
+		{2, 5, shareKey{"srv", "projects"}, true},
+		{1, 9, shareKey{}, false},
+		{3, 5, shareKey{}, false},
+		// Without a session, only unique tree IDs can be mapped
+		{0, 7, shareKey{"srv", "home"}, true},
+		{0, 5, shareKey{}, false},
+		{0, 1, shareKey{}, false},
+	}
+	for _, test := range tests {
+		if got, ok := trees.lookup(test.session, test.tree); got != test.want || ok != test.ok {
+			t.Errorf("lookup(%d, %d) = %+v %v, want %+v %v", test.session, test.tree, got, ok, test.want, test.ok)
+		}
+	}
+	want := []shareKey{{"srv", "data"}, {"srv", "home"}, {"srv", "projects"}, {"srv", "empty"}}
+	if !reflect.DeepEqual(trees.shares, want) {
+		t.Errorf("shares = %+v, want %+v", trees.shares, want)
+	}
+}
+
+func TestOpenFileMetrics(t *testing.T) {
+	files := &cifs.OpenFiles{Files: []*cifs.OpenFile{
+		{SessionID: 1, TreeID: 5, PID: 10, UID: 0, Filename: "a"},
+		{SessionID: 1, TreeID: 5, PID: 10, UID: 0, Filename: "a"},
+		{SessionID: 2, TreeID: 5, PID: 11, UID: 1000, Filename: "b"},
+		{SessionID: 9, TreeID: 5, PID: 12, UID: 0, Filename: "c"},
+		{SessionID: 0, TreeID: 5, PID: 12, UID: 0, Filename: "d"},
+	}}
+	m := newOpenFileMetrics()
+	got := collectMetrics(t, "cifs_open_handles", func(ch chan<- prometheus.Metric) {
+		m.collect(ch, files, testTrees, Config{OpenFilesByProcess: true})
+	})
+	want := []string{
+		`cifs_open_handles_by_process{pid="10",server="srv",share="data",uid="0"} 2`,
+		`cifs_open_handles_by_process{pid="11",server="srv",share="projects",uid="1000"} 1`,
+		`cifs_open_handles_unmapped{} 2`,
+		`cifs_open_handles{server="srv",share="data"} 2`,
+		`cifs_open_handles{server="srv",share="empty"} 0`,
+		`cifs_open_handles{server="srv",share="home"} 0`,
+		`cifs_open_handles{server="srv",share="projects"} 1`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestOpenFileMetricsWithoutDebugData(t *testing.T) {
+	files := &cifs.OpenFiles{Files: []*cifs.OpenFile{{SessionID: 1, TreeID: 5}, {SessionID: 1, TreeID: 7}}}
+	got := collectMetrics(t, "cifs_open_handles", func(ch chan<- prometheus.Metric) {
+		newOpenFileMetrics().collect(ch, files, nil, Config{OpenFilesByProcess: true, OpenFilesByFile: true})
+	})
+	want := []string{`cifs_open_handles_unmapped{} 2`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
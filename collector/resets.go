@@ -0,0 +1,213 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// resetTracker remembers the last value of every counter, so we notice when the counters
+// go backwards. This happens if somebody writes to /proc/fs/cifs/Stats to clear the statistics,
+// or if a share is mounted again. Counters and scopes we don't see in a scrape are forgotten,
+// so unmounted shares don't stay around forever.
+type resetTracker struct {
+	// last holds the merged value of every counter and offsets what we add to it after resets.
+	last    map[counterKey]uint64
+	offsets map[counterKey]uint64
+	// parts holds the values of every tree or TCP connection, which the kernel prints separately,
+	// by the scope and the name of the group we observed them with.
+	parts map[counterKey][]map[string]uint64
+	// resets and lastReset are stored per share, the empty share is the Stats header.
+	resets    map[shareKey]uint64
+	lastReset map[shareKey]time.Time
+	scopes    map[shareKey]bool
+	// seen, seenParts and seenScopes are the counters, groups and shares of the current scrape
+	seen       map[counterKey]bool
+	seenParts  map[counterKey]bool
+	seenScopes map[shareKey]bool
+	now        func() time.Time
+}
+
+// counterKey identifies a counter of a share. Counters which don't belong to a share,
+// like the Stats header, have an empty share.
+type counterKey struct {
+	shareKey
+	name string
+}
+
+func newResetTracker() *resetTracker {
+	return &resetTracker{
+		last:      map[counterKey]uint64{},
+		offsets:   map[counterKey]uint64{},
+		parts:     map[counterKey][]map[string]uint64{},
+		resets:    map[shareKey]uint64{},
+		lastReset: map[shareKey]time.Time{},
+		scopes:    map[shareKey]bool{},
+		now:       time.Now,
+	}
+}
+
+// begin starts a new scrape.
+func (t *resetTracker) begin() {
+	t.seen = map[counterKey]bool{}
+	t.seenParts = map[counterKey]bool{}
+	t.seenScopes = map[shareKey]bool{}
+}
+
+// prune forgets all counters and scopes we did not see since begin. The Stats header is kept.
+// A share which comes back later starts from zero again.
+func (t *resetTracker) prune() {
+	for key := range t.last {
+		if !t.seen[key] {
+			delete(t.last, key)
+			delete(t.offsets, key)
+		}
+	}
+	for key := range t.parts {
+		if !t.seenParts[key] {
+			delete(t.parts, key)
+		}
+	}
+	for scope := range t.scopes {
+		if scope != (shareKey{}) && !t.seenScopes[scope] {
+			delete(t.scopes, scope)
+			delete(t.resets, scope)
+			delete(t.lastReset, scope)
+		}
+	}
+}
+
+// observe stores the counters of a group, like the counters of a share or the timings of a server.
+// The kernel prints the values of every tree or TCP connection separately, parts holds one map
+// per connection by counter name. observe returns the sum of all parts accumulated over all resets
+// and whether one of the sums went backwards since the last scrape.
+//
+// A connection is the same as in the last scrape if none of its counters went backwards. We add the
+// last values of the connections we can't find anymore to the offsets of their counters, so a cleared
+// Stats file counts every counter again, even those which grew past their old value already. If
+// only one tree connection of a share is unmounted, the other ones are not counted twice.
+func (t *resetTracker) observe(group counterKey, parts []map[string]uint64) (map[string]uint64, bool) {
+	values := map[string]uint64{}
+	for _, part := range parts {
+		for name, v := range part {
+			values[name] += v
+		}
+	}
+	reset := false
+	for name, v := range values {
+		if last, ok := t.last[counterKey{group.shareKey, name}]; ok && v < last {
+			reset = true
+		}
+	}
+	// Every connection of this scrape is matched with the connection of the last scrape with the
+	// highest values, which are not higher than its own
+	last := t.parts[group]
+	matched := make([]bool, len(last))
+	for _, part := range parts {
+		best := -1
+		for i, l := range last {
+			if !matched[i] && !decreased(l, part) && (best < 0 || sum(l) > sum(last[best])) {
+				best = i
+			}
+		}
+		if best >= 0 {
+			matched[best] = true
+		}
+	}
+	for i, l := range last {
+		if matched[i] {
+			continue
+		}
+		for name, v := range l {
+			t.offsets[counterKey{group.shareKey, name}] += v
+		}
+	}
+	t.parts[group] = parts
+	if t.seenParts != nil {
+		t.seenParts[group] = true
+	}
+	accumulated := make(map[string]uint64, len(values))
+	for name, v := range values {
+		key := counterKey{group.shareKey, name}
+		t.last[key] = v
+		if t.seen != nil {
+			t.seen[key] = true
+		}
+		accumulated[name] = t.offsets[key] + v
+	}
+	return accumulated, reset
+}
+
+// sum returns the sum of all counters of a connection.
+func sum(part map[string]uint64) uint64 {
+	var s uint64
+	for _, v := range part {
+		s += v
+	}
+	return s
+}
+
+// decreased reports whether a counter in current is lower than in last, or missing.
+func decreased(last, current map[string]uint64) bool {
+	for name, v := range last {
+		if c, ok := current[name]; !ok || c < v {
+			return true
+		}
+	}
+	return false
+}
+
+// scope registers a share, so we export it even without any reset.
+func (t *resetTracker) scope(scope shareKey) {
+	t.scopes[scope] = true
+	if t.seenScopes != nil {
+		t.seenScopes[scope] = true
+	}
+}
+
+// reset counts a reset of a share.
+func (t *resetTracker) reset(scope shareKey) {
+	t.resets[scope]++
+	t.lastReset[scope] = t.now()
+}
+
+// resetMetrics exports how often the Stats counters have been reset.
+type resetMetrics struct {
+	resets         *prometheus.Desc
+	lastReset      *prometheus.Desc
+	shareResets    *prometheus.Desc
+	shareLastReset *prometheus.Desc
+}
+
+func newResetMetrics() *resetMetrics {
+	return &resetMetrics{
+		resets:         prometheus.NewDesc("cifs_stats_reset_total", "Number of times the counters in the Stats header went backwards, for example because the statistics were cleared", nil, nil),
+		lastReset:      prometheus.NewDesc("cifs_stats_last_reset_timestamp_seconds", "Unix timestamp of the last time the counters in the Stats header went backwards", nil, nil),
+		shareResets:    prometheus.NewDesc("cifs_share_stats_reset_total", "Number of times the counters of a share went backwards, for example because the statistics were cleared or the share was mounted again", shareLabels, nil),
+		shareLastReset: prometheus.NewDesc("cifs_share_stats_last_reset_timestamp_seconds", "Unix timestamp of the last time the counters of a share went backwards", shareLabels, nil),
+	}
+}
+
+func (m *resetMetrics) describe(ch chan<- *prometheus.Desc) {
+	ch <- m.resets
+	ch <- m.lastReset
+	ch <- m.shareResets
+	ch <- m.shareLastReset
+}
+
+// collect exports the resets of the header and every share we have seen so far.
+func (m *resetMetrics) collect(ch chan<- prometheus.Metric, t *resetTracker) {
+	ch <- prometheus.MustNewConstMetric(m.resets, prometheus.CounterValue, float64(t.resets[shareKey{}]))
+	if last, ok := t.lastReset[shareKey{}]; ok {
+		ch <- prometheus.MustNewConstMetric(m.lastReset, prometheus.GaugeValue, float64(last.UnixNano())/1e9)
+	}
+	for scope := range t.scopes {
+		if scope == (shareKey{}) {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(m.shareResets, prometheus.CounterValue, float64(t.resets[scope]), scope.server, scope.share)
+		if last, ok := t.lastReset[scope]; ok {
+			ch <- prometheus.MustNewConstMetric(m.shareLastReset, prometheus.GaugeValue, float64(last.UnixNano())/1e9, scope.server, scope.share)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package collector
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shibumi/cifs-exporter/cifs"
+)
+
+// observeShare observes the tree connections of a share, each part is a map of counter values.
+func observeShare(tracker *resetTracker, share string, parts ...map[string]uint64) (map[string]uint64, bool) {
+	return tracker.observe(counterKey{shareKey{"srv", share}, "stats"}, parts)
+}
+
+func TestResetTracker(t *testing.T) {
+	tracker := newResetTracker()
+	now := time.Unix(1700000000, 0)
+	tracker.now = func() time.Time { return now }
+
+	scrape := func(values map[string]uint64) map[string]bool {
+		tracker.begin()
+		resets := map[string]bool{}
+		for share, v := range values {
+			scope := shareKey{"srv", share}
+			tracker.scope(scope)
+			_, reset := observeShare(tracker, share, map[string]uint64{"smbs": v})
+			if reset {
+				tracker.reset(scope)
+			}
+			resets[share] = reset
+		}
+		tracker.prune()
+		return resets
+	}
+
+	scrape(map[string]uint64{"a": 10, "b": 5})
+	if resets := scrape(map[string]uint64{"a": 3, "b": 6}); !resets["a"] || resets["b"] {
+		t.Errorf("resets = %v, want a only", resets)
+	}
+	a, b := shareKey{"srv", "a"}, shareKey{"srv", "b"}
+	if got, _ := observeShare(tracker, "a", map[string]uint64{"smbs": 4}); got["smbs"] != 14 {
+		t.Errorf("accumulated = %d, want 14", got["smbs"])
+	}
+	if tracker.resets[a] != 1 || !tracker.lastReset[a].Equal(now) {
+		t.Errorf("resets of a = %d at %v", tracker.resets[a], tracker.lastReset[a])
+	}
+
+	// b is unmounted, the tracker forgets it
+	scrape(map[string]uint64{"a": 4})
+	if tracker.scopes[b] {
+		t.Error("scope b not pruned")
+	}
+	if _, ok := tracker.last[counterKey{b, "smbs"}]; ok {
+		t.Error("counter of b not pruned")
+	}
+	if _, ok := tracker.parts[counterKey{b, "stats"}]; ok {
+		t.Error("connections of b not pruned")
+	}
+	if !tracker.scopes[a] || tracker.resets[a] != 1 {
+		t.Error("scope a pruned")
+	}
+
+	// b is mounted again, a lower value is no reset because we forgot the old one
+	if resets := scrape(map[string]uint64{"a": 4, "b": 1}); resets["b"] {
+		t.Error("reset of b counted after it was pruned")
+	}
+}
+
+func TestResetTrackerOffsetsEveryCounter(t *testing.T) {
+	tracker := newResetTracker()
+	observeShare(tracker, "a", map[string]uint64{"reads": 10, "writes": 5})
+	// The statistics were cleared, writes grew past its old value before the next scrape
+	got, reset := observeShare(tracker, "a", map[string]uint64{"reads": 2, "writes": 7})
+	if !reset {
+		t.Error("reset not detected")
+	}
+	if want := map[string]uint64{"reads": 12, "writes": 12}; !reflect.DeepEqual(got, want) {
+		t.Errorf("accumulated = %v, want %v", got, want)
+	}
+	got, _ = observeShare(tracker, "a", map[string]uint64{"reads": 3, "writes": 8})
+	if want := map[string]uint64{"reads": 13, "writes": 13}; !reflect.DeepEqual(got, want) {
+		t.Errorf("accumulated = %v, want %v", got, want)
+	}
+}
+
+func TestResetTrackerUnmountInMergedShare(t *testing.T) {
+	tracker := newResetTracker()
+	tests := []struct {
+		name        string
+		parts       []map[string]uint64
+		accumulated uint64
+		reset       bool
+	}{
+		{"two mounts", []map[string]uint64{{"smbs": 10}, {"smbs": 20}}, 30, false},
+		{"first mount unmounted", []map[string]uint64{{"smbs": 25}}, 35, true},
+		{"remaining mount grows", []map[string]uint64{{"smbs": 27}}, 37, false},
+		{"mounted again", []map[string]uint64{{"smbs": 28}, {"smbs": 1}}, 39, false},
+		{"statistics cleared", []map[string]uint64{{"smbs": 0}, {"smbs": 0}}, 39, true},
+		{"after the clear", []map[string]uint64{{"smbs": 2}, {"smbs": 1}}, 42, false},
+	}
+	for _, test := range tests {
+		got, reset := observeShare(tracker, "data", test.parts...)
+		if got["smbs"] != test.accumulated || reset != test.reset {
+			t.Errorf("%s: accumulated = %d, reset = %v, want %d, %v", test.name, got["smbs"], reset, test.accumulated, test.reset)
+		}
+	}
+}
+
+func TestShareCountersUnmountInMergedShare(t *testing.T) {
+	block := func(smbs, creates uint64) *cifs.Block {
+		return &cifs.Block{Server: "srv", Share: "data", Dialect: cifs.DialectSMB2, SMBs: smbs,
+			Counters: map[string]uint64{}, Commands: map[string]cifs.Command{"Creates": {Sent: creates}}}
+	}
+	m := newShareMetrics()
+	tracker := newResetTracker()
+	collect := func(blocks ...*cifs.Block) []string {
+		return collectMetrics(t, "cifs_", func(ch chan<- prometheus.Metric) {
+			tracker.begin()
+			m.collect(ch, blocks, Config{Accumulate: true}, tracker)
+			tracker.prune()
+		})
+	}
+	collect(block(10, 4), block(20, 6))
+	// The first mount is gone, the second one must not be counted twice
+	got := collect(block(21, 7))
+	want := []string{
+		`cifs_creates_failed_total{server="srv",share="data"} 0`,
+		`cifs_creates_sent_total{server="srv",share="data"} 11`,
+		`cifs_smbs_total{server="srv",share="data"} 31`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if tracker.resets[shareKey{"srv", "data"}] != 1 {
+		t.Errorf("resets = %d, want 1", tracker.resets[shareKey{"srv", "data"}])
+	}
+}
+
+func TestResetTrackerKeepsHeader(t *testing.T) {
+	tracker := newResetTracker()
+	tracker.begin()
+	tracker.observe(counterKey{name: "header"}, []map[string]uint64{{"header/0": 5}})
+	tracker.reset(shareKey{})
+	tracker.prune()
+	// A scrape without the header counters, the reset of the header must survive it
+	tracker.begin()
+	tracker.prune()
+	if tracker.resets[shareKey{}] != 1 {
+		t.Errorf("header resets = %d, want 1", tracker.resets[shareKey{}])
+	}
+}
+
+func TestResetTrackerSharesDontCollide(t *testing.T) {
+	tracker := newResetTracker()
+	tracker.begin()
+	tracker.observe(counterKey{shareKey{"ab", "c"}, "stats"}, []map[string]uint64{{"smbs": 10}})
+	// \\a\bc has a lower value, it is another share and no reset of \\ab\c
+	if _, reset := tracker.observe(counterKey{shareKey{"a", "bc"}, "stats"}, []map[string]uint64{{"smbs": 1}}); reset {
+		t.Error("reset of another share counted")
+	}
+}
@@ -0,0 +1,254 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shibumi/cifs-exporter/cifs"
+)
+
+// shareLabels are the variable labels of every per share metric.
+var shareLabels = []string{"server", "share"}
+
+// shareKey identifies a share. Server and share names are kept apart,
+// so \\ab\c and \\a\bc are different shares.
+type shareKey struct {
+	server string
+	share  string
+}
+
+// smb1Counters are all single value fields the kernel prints for SMB1 shares,
+// and the open files newer kernels print for SMB2 shares.
+var smb1Counters = []string{
+	"Reads", "Writes", "Flushes", "Locks", "HardLinks", "Symlinks", "Opens", "Closes", "Deletes",
+	"Posix Opens", "Posix Mkdirs", "Mkdirs", "Rmdirs", "Renames", "T2 Renames", "FindFirst", "FNext", "FClose",
+	"Open files", "Open files on server",
+}
+
+// smb2Commands are all commands the kernel prints for SMB2 shares.
+var smb2Commands = []string{
+	"Negotiates", "SessionSetups", "Logoffs", "TreeConnects", "TreeDisconnects", "Creates", "Closes", "Flushes",
+	"Reads", "Writes", "Locks", "IOCTLs", "Cancels", "Echos", "QueryDirectories", "ChangeNotifies",
+	"QueryInfos", "SetInfos", "OplockBreaks",
+}
+
+// shareMetric is a per share metric with its legacy gauge.
+type shareMetric struct {
+	name      string
+	desc      *prometheus.Desc
+	legacy    *prometheus.Desc
+	valueType prometheus.ValueType
+}
+
+// shareMetrics holds the descriptors of all per share metrics. They are created once,
+// so the registry knows all of them and can check every scrape against them.
+type shareMetrics struct {
+	smbs         shareMetric
+	oplockBreaks shareMetric
+	readBytes    shareMetric
+	writeBytes   shareMetric
+	counters     map[string]shareMetric
+	sent         map[string]shareMetric
+	failed       map[string]shareMetric
+	// Fields we don't know yet are exported with the field name as label.
+	otherCounters *prometheus.Desc
+	otherSent     *prometheus.Desc
+	otherFailed   *prometheus.Desc
+}
+
+func newShareMetric(name, legacy, help string, valueType prometheus.ValueType) shareMetric {
+	return shareMetric{
+		name:      name,
+		desc:      prometheus.NewDesc(name, help, shareLabels, nil),
+		legacy:    prometheus.NewDesc(legacy, help, shareLabels, nil),
+		valueType: valueType,
+	}
+}
+
+func newShareMetrics() *shareMetrics {
+	m := &shareMetrics{
+		smbs:          newShareMetric("cifs_smbs_total", "cifs_total_smb", "Number of SMBs sent", prometheus.CounterValue),
+		oplockBreaks:  newShareMetric("cifs_oplock_breaks_total", "cifs_total_oplocks", "Number of oplock breaks", prometheus.CounterValue),
+		readBytes:     newShareMetric("cifs_read_bytes_total", "cifs_total_read_bytes", "Number of bytes read", prometheus.CounterValue),
+		writeBytes:    newShareMetric("cifs_write_bytes_total", "cifs_total_write_bytes", "Number of bytes written", prometheus.CounterValue),
+		counters:      map[string]shareMetric{},
+		sent:          map[string]shareMetric{},
+		failed:        map[string]shareMetric{},
+		otherCounters: prometheus.NewDesc("cifs_other_stats", "Values of Stats fields the exporter does not know yet", append(shareLabels, "field"), nil),
+		otherSent:     prometheus.NewDesc("cifs_other_commands_sent_total", "Number of requests sent for commands the exporter does not know yet", append(shareLabels, "command"), nil),
+		otherFailed:   prometheus.NewDesc("cifs_other_commands_failed_total", "Number of requests failed for commands the exporter does not know yet", append(shareLabels, "command"), nil),
+	}
+	for _, field := range smb1Counters {
+		n, legacy := metricName(field), "cifs_total_"+legacyMetricName(field)
+		if gaugeCounters[field] {
+			m.counters[field] = newShareMetric("cifs_"+n, legacy, "Number of "+helpName(n), prometheus.GaugeValue)
+			continue
+		}
+		m.counters[field] = newShareMetric("cifs_"+n+"_total", legacy, "Number of "+helpName(n), prometheus.CounterValue)
+	}
+	for _, command := range smb2Commands {
+		n, legacy := metricName(command), "cifs_total_"+legacyMetricName(command)
+		m.sent[command] = newShareMetric("cifs_"+n+"_sent_total", legacy+"_sent", "Number of "+helpName(n)+" sent", prometheus.CounterValue)
+		m.failed[command] = newShareMetric("cifs_"+n+"_failed_total", legacy+"_failed", "Number of "+helpName(n)+" failed", prometheus.CounterValue)
+	}
+	return m
+}
+
+// all returns every shareMetric, the legacy descriptors are included.
+func (m *shareMetrics) all() []shareMetric {
+	all := []shareMetric{m.smbs, m.oplockBreaks, m.readBytes, m.writeBytes}
+	for _, field := range smb1Counters {
+		all = append(all, m.counters[field])
+	}
+	for _, command := range smb2Commands {
+		all = append(all, m.sent[command], m.failed[command])
+	}
+	return all
+}
+
+func (m *shareMetrics) describe(ch chan<- *prometheus.Desc) {
+	// We always describe the legacy metrics, so they can be enabled without registering the collector again.
+	for _, sm := range m.all() {
+		ch <- sm.desc
+		ch <- sm.legacy
+	}
+	ch <- m.otherCounters
+	ch <- m.otherSent
+	ch <- m.otherFailed
+}
+
+// collect exports all values of all shares.
+// Everything is a counter, except the open files which can go down again.
+// The counters of every tree connection are passed through the reset tracker, if one of the
+// sums goes backwards we count a reset of the share.
+func (m *shareMetrics) collect(ch chan<- prometheus.Metric, blocks []*cifs.Block, config Config, t *resetTracker) {
+	parts := map[shareKey][]map[string]uint64{}
+	for _, block := range blocks {
+		key := shareKey{block.Server, block.Share}
+		parts[key] = append(parts[key], m.blockCounters(block))
+	}
+	for _, block := range mergeBlocks(blocks) {
+		scope := shareKey{block.Server, block.Share}
+		t.scope(scope)
+		accumulated, reset := t.observe(counterKey{scope, "stats"}, parts[scope])
+		if reset {
+			t.reset(scope)
+		}
+		// counter returns the value we export for a counter, depending on config.Accumulate
+		counter := func(name string, value uint64) uint64 {
+			if config.Accumulate {
+				return accumulated[name]
+			}
+			return value
+		}
+		// emit exports a value under its new name and, if enabled, under its legacy gauge name
+		emit := func(sm shareMetric, value uint64) {
+			v := value
+			if sm.valueType == prometheus.CounterValue {
+				v = counter(sm.name, value)
+			}
+			ch <- prometheus.MustNewConstMetric(sm.desc, sm.valueType, float64(v), block.Server, block.Share)
+			if config.LegacyNames {
+				ch <- prometheus.MustNewConstMetric(sm.legacy, prometheus.GaugeValue, float64(value), block.Server, block.Share)
+			}
+		}
+		emit(m.smbs, block.SMBs)
+		if block.Dialect == cifs.DialectSMB1 {
+			emit(m.oplockBreaks, block.OplockBreaks)
+		}
+		// The bytes don't depend on the dialect, we export them whenever the kernel printed them
+		if block.HasBytes {
+			emit(m.readBytes, block.BytesRead)
+			emit(m.writeBytes, block.BytesWritten)
+		}
+		for _, name := range block.CounterNames() {
+			if sm, ok := m.counters[name]; ok {
+				emit(sm, block.Counters[name])
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(m.otherCounters, prometheus.UntypedValue, float64(block.Counters[name]), block.Server, block.Share, name)
+		}
+		for _, name := range block.CommandNames() {
+			cmd := block.Commands[name]
+			if _, ok := m.sent[name]; ok {
+				emit(m.sent[name], cmd.Sent)
+				emit(m.failed[name], cmd.Failed)
+				continue
+			}
+			sent, failed := counter("sent/"+name, cmd.Sent), counter("failed/"+name, cmd.Failed)
+			ch <- prometheus.MustNewConstMetric(m.otherSent, prometheus.CounterValue, float64(sent), block.Server, block.Share, name)
+			ch <- prometheus.MustNewConstMetric(m.otherFailed, prometheus.CounterValue, float64(failed), block.Server, block.Share, name)
+		}
+	}
+}
+
+// blockCounters returns the counters of a single tree connection by the names collect uses for them.
+func (m *shareMetrics) blockCounters(block *cifs.Block) map[string]uint64 {
+	counters := map[string]uint64{m.smbs.name: block.SMBs}
+	if block.Dialect == cifs.DialectSMB1 {
+		counters[m.oplockBreaks.name] = block.OplockBreaks
+	}
+	if block.HasBytes {
+		counters[m.readBytes.name] = block.BytesRead
+		counters[m.writeBytes.name] = block.BytesWritten
+	}
+	for name, v := range block.Counters {
+		if sm, ok := m.counters[name]; ok && sm.valueType == prometheus.CounterValue {
+			counters[sm.name] = v
+		}
+	}
+	for name, cmd := range block.Commands {
+		if _, ok := m.sent[name]; ok {
+			counters[m.sent[name].name] = cmd.Sent
+			counters[m.failed[name].name] = cmd.Failed
+			continue
+		}
+		counters["sent/"+name] = cmd.Sent
+		counters["failed/"+name] = cmd.Failed
+	}
+	return counters
+}
+
+// mergeBlocks merges all blocks of the same \\server\share into one block.
+// The kernel prints a block per tree connection, so the same share shows up more than once
+// if it is mounted by different users or with different options. We add up all values,
+// so the sum is the same as the sum over all tree connections. The blocks keep the order of
+// their first appearance, so we export them deterministically.
+func mergeBlocks(blocks []*cifs.Block) []*cifs.Block {
+	merged := make([]*cifs.Block, 0, len(blocks))
+	byShare := map[shareKey]*cifs.Block{}
+	for _, block := range blocks {
+		key := shareKey{block.Server, block.Share}
+		m, ok := byShare[key]
+		if !ok {
+			m = &cifs.Block{
+				Server:       block.Server,
+				Share:        block.Share,
+				Dialect:      block.Dialect,
+				Disconnected: true,
+				Counters:     map[string]uint64{},
+				Commands:     map[string]cifs.Command{},
+			}
+			byShare[key] = m
+			merged = append(merged, m)
+		}
+		if m.Dialect == cifs.DialectUnknown {
+			m.Dialect = block.Dialect
+		}
+		// The share is only disconnected if all of its tree connections are
+		m.Disconnected = m.Disconnected && block.Disconnected
+		m.SMBs += block.SMBs
+		m.OplockBreaks += block.OplockBreaks
+		m.BytesRead += block.BytesRead
+		m.BytesWritten += block.BytesWritten
+		m.HasBytes = m.HasBytes || block.HasBytes
+		for name, v := range block.Counters {
+			m.Counters[name] += v
+		}
+		for name, cmd := range block.Commands {
+			c := m.Commands[name]
+			c.Sent += cmd.Sent
+			c.Failed += cmd.Failed
+			m.Commands[name] = c
+		}
+	}
+	return merged
+}
@@ -0,0 +1,65 @@
+package collector
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shibumi/cifs-exporter/cifs"
+)
+
+func TestMergeBlocks(t *testing.T) {
+	block := func(server, share string, smbs uint64, disconnected bool) *cifs.Block {
+		return &cifs.Block{Server: server, Share: share, Dialect: cifs.DialectSMB2, SMBs: smbs, Disconnected: disconnected,
+			Counters: map[string]uint64{}, Commands: map[string]cifs.Command{"Creates": {Sent: smbs, Failed: 1}}}
+	}
+	merged := mergeBlocks([]*cifs.Block{
+		block("srv", "data", 3, false),
+		block("ab", "c", 5, true),
+		block("srv", "data", 4, true),
+		// \\a\bc must not be merged into \\ab\c
+		block("a", "bc", 7, false),
+	})
+	type summary struct {
+		server, share string
+		smbs          uint64
+		creates       cifs.Command
+		disconnected  bool
+	}
+	var got []summary
+	for _, b := range merged {
+		got = append(got, summary{b.Server, b.Share, b.SMBs, b.Commands["Creates"], b.Disconnected})
+	}
+	want := []summary{
+		{"srv", "data", 7, cifs.Command{Sent: 7, Failed: 2}, false},
+		{"ab", "c", 5, cifs.Command{Sent: 5, Failed: 1}, true},
+		{"a", "bc", 7, cifs.Command{Sent: 7, Failed: 1}, false},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("merged = %+v, want %+v", got, want)
+	}
+}
+
+func TestShareUpKeepsSharesApart(t *testing.T) {
+	blocks := []*cifs.Block{
+		{Server: "ab", Share: "c"},
+		{Server: "a", Share: "bc"},
+	}
+	data := &cifs.DebugData{Connections: []*cifs.Connection{{
+		ConnectionState: cifs.ConnectionState{Status: cifs.StatusGood},
+		Sessions: []*cifs.Session{{Status: cifs.StatusGood, Shares: []*cifs.Tree{
+			{Server: "ab", Share: "c", Status: cifs.StatusGood},
+			{Server: "a", Share: "bc", Status: 3},
+		}}},
+	}}}
+	m := newStatusMetrics()
+	got := collectMetrics(t, "cifs_share_up", func(ch chan<- prometheus.Metric) { m.collect(ch, blocks, data) })
+	want := []string{
+		`cifs_share_up{server="a",share="bc"} 0`,
+		`cifs_share_up{server="ab",share="c"} 1`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
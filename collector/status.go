@@ -0,0 +1,138 @@
+package collector
+
+import (
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shibumi/cifs-exporter/cifs"
+)
+
+// The reasons we export with cifs_up_reason_info, so on-call can tell why there are no shares available.
+const (
+	upReasonOK                    = "ok"
+	upReasonModuleNotLoaded       = "module_not_loaded"
+	upReasonPermissionDenied      = "permission_denied"
+	upReasonStatsUnreadable       = "stats_unreadable"
+	upReasonNoShares              = "no_shares"
+	upReasonAllSharesDisconnected = "all_shares_disconnected"
+)
+
+// upReasons are all reasons, every scrape exports each of them, so the series don't come and go.
+var upReasons = []string{
+	upReasonOK,
+	upReasonModuleNotLoaded,
+	upReasonPermissionDenied,
+	upReasonStatsUnreadable,
+	upReasonNoShares,
+	upReasonAllSharesDisconnected,
+}
+
+// statusMetrics exports whether the CIFS module is loaded and whether its shares are available.
+type statusMetrics struct {
+	up            *prometheus.Desc
+	upReason      *prometheus.Desc
+	moduleLoaded  *prometheus.Desc
+	statsReadable *prometheus.Desc
+	sharesMounted *prometheus.Desc
+	shareUp       *prometheus.Desc
+}
+
+func newStatusMetrics() *statusMetrics {
+	return &statusMetrics{
+		up:            prometheus.NewDesc("cifs_up", "Boolean gauge of 1 if at least one cifs share is available, or 0 if not", nil, nil),
+		upReason:      prometheus.NewDesc("cifs_up_reason_info", "Boolean gauge of 1 for the reason of the value of cifs_up, and 0 for all other reasons", []string{"reason"}, nil),
+		moduleLoaded:  prometheus.NewDesc("cifs_module_loaded", "Boolean gauge of 1 if the cifs kernel module is loaded, or 0 if not", nil, nil),
+		statsReadable: prometheus.NewDesc("cifs_stats_readable", "Boolean gauge of 1 if /proc/fs/cifs/Stats could be read, or 0 if not", nil, nil),
+		sharesMounted: prometheus.NewDesc("cifs_shares_mounted", "Number of distinct shares in /proc/fs/cifs/Stats", nil, nil),
+		shareUp:       prometheus.NewDesc("cifs_share_up", "Boolean gauge of 1 if the share is connected, or 0 if it waits for a reconnect", shareLabels, nil),
+	}
+}
+
+func (m *statusMetrics) describe(ch chan<- *prometheus.Desc) {
+	ch <- m.up
+	ch <- m.upReason
+	ch <- m.moduleLoaded
+	ch <- m.statsReadable
+	ch <- m.sharesMounted
+	ch <- m.shareUp
+}
+
+// collectFailure exports the status if we could not read the Stats file.
+func (m *statusMetrics) collectFailure(ch chan<- prometheus.Metric, moduleLoaded bool, err error) {
+	reason := upReasonStatsUnreadable
+	switch {
+	case !moduleLoaded:
+		reason = upReasonModuleNotLoaded
+	case os.IsPermission(err):
+		reason = upReasonPermissionDenied
+	}
+	m.collectUp(ch, false, reason)
+	ch <- prometheus.MustNewConstMetric(m.moduleLoaded, prometheus.GaugeValue, boolToFloat(moduleLoaded))
+	ch <- prometheus.MustNewConstMetric(m.statsReadable, prometheus.GaugeValue, 0)
+	ch <- prometheus.MustNewConstMetric(m.sharesMounted, prometheus.GaugeValue, 0)
+}
+
+// collect exports the status of every share. A share is up if the Stats file does not mark it
+// as DISCONNECTED and, if we could read DebugData, one of its tree connections, the session
+// and the TCP connection are good. data may be nil.
+func (m *statusMetrics) collect(ch chan<- prometheus.Metric, blocks []*cifs.Block, data *cifs.DebugData) {
+	var trees map[shareKey]bool
+	if data != nil {
+		trees = treesUp(data)
+	}
+	shares := mergeBlocks(blocks)
+	available := 0
+	for _, block := range shares {
+		up := !block.Disconnected
+		if treeUp, ok := trees[shareKey{block.Server, block.Share}]; ok {
+			up = up && treeUp
+		}
+		if up {
+			available++
+		}
+		ch <- prometheus.MustNewConstMetric(m.shareUp, prometheus.GaugeValue, boolToFloat(up), block.Server, block.Share)
+	}
+	reason := upReasonOK
+	switch {
+	case len(shares) == 0:
+		reason = upReasonNoShares
+	case available == 0:
+		reason = upReasonAllSharesDisconnected
+	}
+	m.collectUp(ch, available > 0, reason)
+	ch <- prometheus.MustNewConstMetric(m.moduleLoaded, prometheus.GaugeValue, 1)
+	ch <- prometheus.MustNewConstMetric(m.statsReadable, prometheus.GaugeValue, 1)
+	ch <- prometheus.MustNewConstMetric(m.sharesMounted, prometheus.GaugeValue, float64(len(shares)))
+}
+
+// collectUp exports cifs_up and sets the reason to 1, all other reasons to 0.
+func (m *statusMetrics) collectUp(ch chan<- prometheus.Metric, up bool, reason string) {
+	ch <- prometheus.MustNewConstMetric(m.up, prometheus.GaugeValue, boolToFloat(up))
+	for _, r := range upReasons {
+		ch <- prometheus.MustNewConstMetric(m.upReason, prometheus.GaugeValue, boolToFloat(r == reason), r)
+	}
+}
+
+// treesUp returns for every share in DebugData whether one of its tree connections is usable.
+func treesUp(data *cifs.DebugData) map[shareKey]bool {
+	trees := map[shareKey]bool{}
+	for _, conn := range data.Connections {
+		for _, session := range conn.Sessions {
+			for _, tree := range session.Shares {
+				if tree.IPC {
+					continue
+				}
+				key := shareKey{tree.Server, tree.Share}
+				trees[key] = trees[key] || (conn.Up() && session.Status == cifs.StatusGood && tree.Status == cifs.StatusGood && !tree.Disconnected)
+			}
+		}
+	}
+	return trees
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
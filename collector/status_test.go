@@ -0,0 +1,74 @@
+package collector
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shibumi/cifs-exporter/cifs"
+)
+
+// upMetrics returns cifs_up and the reasons of cifs_up_reason_info which are 1.
+func upMetrics(t *testing.T, collect func(ch chan<- prometheus.Metric)) (string, []string) {
+	t.Helper()
+	up := ""
+	var reasons []string
+	for _, metric := range collectMetrics(t, "cifs_up", collect) {
+		switch {
+		case strings.HasPrefix(metric, "cifs_up{}"):
+			up = metric
+		case strings.HasSuffix(metric, " 1"):
+			reasons = append(reasons, metric)
+		case !strings.HasSuffix(metric, " 0"):
+			t.Errorf("unexpected value: %s", metric)
+		}
+	}
+	return up, reasons
+}
+
+func TestStatusUpReason(t *testing.T) {
+	m := newStatusMetrics()
+	share := func(name string, disconnected bool) *cifs.Block {
+		return &cifs.Block{Server: "srv", Share: name, Disconnected: disconnected}
+	}
+	tests := []struct {
+		name    string
+		collect func(ch chan<- prometheus.Metric)
+		up      string
+		reason  string
+	}{
+		{"ok", func(ch chan<- prometheus.Metric) {
+			m.collect(ch, []*cifs.Block{share("data", true), share("home", false)}, nil)
+		}, "1", upReasonOK},
+		{"no shares", func(ch chan<- prometheus.Metric) {
+			m.collect(ch, nil, nil)
+		}, "0", upReasonNoShares},
+		{"all shares disconnected", func(ch chan<- prometheus.Metric) {
+			m.collect(ch, []*cifs.Block{share("data", true)}, nil)
+		}, "0", upReasonAllSharesDisconnected},
+		{"module not loaded", func(ch chan<- prometheus.Metric) {
+			m.collectFailure(ch, false, os.ErrNotExist)
+		}, "0", upReasonModuleNotLoaded},
+		{"permission denied", func(ch chan<- prometheus.Metric) {
+			m.collectFailure(ch, true, os.ErrPermission)
+		}, "0", upReasonPermissionDenied},
+		{"stats unreadable", func(ch chan<- prometheus.Metric) {
+			m.collectFailure(ch, true, os.ErrNotExist)
+		}, "0", upReasonStatsUnreadable},
+	}
+	for _, test := range tests {
+		up, reasons := upMetrics(t, test.collect)
+		if want := "cifs_up{} " + test.up; up != want {
+			t.Errorf("%s: got %q, want %q", test.name, up, want)
+		}
+		if want := []string{`cifs_up_reason_info{reason="` + test.reason + `"} 1`}; !reflect.DeepEqual(reasons, want) {
+			t.Errorf("%s: got %v, want %v", test.name, reasons, want)
+		}
+		// Every reason has a series, even if it is 0
+		if n := len(collectMetrics(t, "cifs_up_reason_info", test.collect)); n != len(upReasons) {
+			t.Errorf("%s: got %d reasons, want %d", test.name, n, len(upReasons))
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shibumi/cifs-exporter/cifs"
+)
+
+// timingMetrics exports the per connection values of the Stats file: the maximum number of requests
+// in flight and the per command timings of kernels built with CONFIG_CIFS_STATS2. The kernel keeps
+// them per connection, not per share, so we label them by server and merge the connections to the same server.
+type timingMetrics struct {
+	maxInFlight      *prometheus.Desc
+	requests         *prometheus.Desc
+	duration         *prometheus.Desc
+	fastest          *prometheus.Desc
+	slowest          *prometheus.Desc
+	slowResponses    *prometheus.Desc
+	largeAllocations *prometheus.Desc
+	smallAllocations *prometheus.Desc
+}
+
+func newTimingMetrics() *timingMetrics {
+	labels := []string{"server", "command"}
+	return &timingMetrics{
+		maxInFlight:      prometheus.NewDesc("cifs_max_requests_in_flight", "Maximum number of requests in flight to the server at the same time", []string{"server"}, nil),
+		requests:         prometheus.NewDesc("cifs_command_requests_total", "Number of SMB2 requests with a response by command", labels, nil),
+		duration:         prometheus.NewDesc("cifs_command_duration_seconds_total", "Total time spent waiting for SMB2 responses by command", labels, nil),
+		fastest:          prometheus.NewDesc("cifs_command_fastest_seconds", "Fastest SMB2 response by command", labels, nil),
+		slowest:          prometheus.NewDesc("cifs_command_slowest_seconds", "Slowest SMB2 response by command", labels, nil),
+		slowResponses:    prometheus.NewDesc("cifs_command_slow_responses_total", "Number of SMB2 responses slower than the slow response threshold by command", labels, nil),
+		largeAllocations: prometheus.NewDesc("cifs_buffer_allocations_total", "Number of SMB request/response buffer allocations", nil, nil),
+		smallAllocations: prometheus.NewDesc("cifs_small_buffer_allocations_total", "Number of small SMB request/response buffer allocations", nil, nil),
+	}
+}
+
+func (m *timingMetrics) describe(ch chan<- *prometheus.Desc) {
+	ch <- m.maxInFlight
+	ch <- m.requests
+	ch <- m.duration
+	ch <- m.fastest
+	ch <- m.slowest
+	ch <- m.slowResponses
+	ch <- m.largeAllocations
+	ch <- m.smallAllocations
+}
+
+// collect exports the timings of every server. The server is the one of the blocks following the
+// timings in the Stats file, connections without a server are skipped.
+func (m *timingMetrics) collect(ch chan<- prometheus.Metric, stats *cifs.ClientStats, config Config, t *resetTracker) {
+	// The maximum of a server is the maximum over all of its connections
+	var servers []string
+	maxInFlight := map[string]uint64{}
+	for _, f := range stats.InFlight {
+		if f.Server == "" {
+			continue
+		}
+		if v, ok := maxInFlight[f.Server]; !ok {
+			servers = append(servers, f.Server)
+		} else if v > f.MaxRequests {
+			continue
+		}
+		maxInFlight[f.Server] = f.MaxRequests
+	}
+	for _, server := range servers {
+		ch <- prometheus.MustNewConstMetric(m.maxInFlight, prometheus.GaugeValue, float64(maxInFlight[server]), server)
+	}
+	if !stats.Stats2 {
+		return
+	}
+	allocations, _ := t.observe(counterKey{name: "allocations"}, []map[string]uint64{{
+		"allocations/large": stats.Header.LargeAllocations,
+		"allocations/small": stats.Header.SmallAllocations,
+	}})
+	// counter returns the value we export for a counter, depending on config.Accumulate
+	counter := func(accumulated map[string]uint64, name string, value uint64) uint64 {
+		if config.Accumulate {
+			return accumulated[name]
+		}
+		return value
+	}
+	ch <- prometheus.MustNewConstMetric(m.largeAllocations, prometheus.CounterValue, float64(counter(allocations, "allocations/large", stats.Header.LargeAllocations)))
+	ch <- prometheus.MustNewConstMetric(m.smallAllocations, prometheus.CounterValue, float64(counter(allocations, "allocations/small", stats.Header.SmallAllocations)))
+	merged, parts := mergeTimings(stats.Timings)
+	for _, timings := range merged {
+		server := timings.Server
+		// The timings belong to the server and not to a share
+		accumulated, _ := t.observe(counterKey{shareKey{server: server}, "timings"}, parts[server])
+		for _, c := range timings.Commands {
+			command := cifs.SMB2CommandName(c.Command)
+			name := timingName(c.Command)
+			ch <- prometheus.MustNewConstMetric(m.requests, prometheus.CounterValue, float64(counter(accumulated, name+"count", c.Count)), server, command)
+			ch <- prometheus.MustNewConstMetric(m.duration, prometheus.CounterValue, timings.Seconds(counter(accumulated, name+"total", c.Total)), server, command)
+			ch <- prometheus.MustNewConstMetric(m.fastest, prometheus.GaugeValue, timings.Seconds(c.Fastest), server, command)
+			ch <- prometheus.MustNewConstMetric(m.slowest, prometheus.GaugeValue, timings.Seconds(c.Slowest), server, command)
+			ch <- prometheus.MustNewConstMetric(m.slowResponses, prometheus.CounterValue, float64(counter(accumulated, name+"slow", c.SlowResponses)), server, command)
+		}
+	}
+}
+
+// timingName returns the prefix of the counter names of a command in the reset tracker.
+func timingName(command int) string {
+	return "timings/" + cifs.SMB2CommandName(command) + "/"
+}
+
+// mergeTimings merges the timings of all connections to the same server. The kernel prints a table
+// per TCP connection, so a server shows up more than once with multichannel or if another user
+// mounts a share of it. Like in mergeBlocks we add up the counts and times, the fastest and slowest
+// response are taken over all connections that saw the command. The servers keep the order of
+// their first appearance. mergeTimings also returns the counters of every connection by server,
+// for the reset tracker. Timings without a server are skipped.
+func mergeTimings(timings []*cifs.ServerTimings) ([]*cifs.ServerTimings, map[string][]map[string]uint64) {
+	var merged []*cifs.ServerTimings
+	byServer := map[string]*cifs.ServerTimings{}
+	parts := map[string][]map[string]uint64{}
+	for _, t := range timings {
+		server := t.Server
+		if server == "" {
+			continue
+		}
+		m, ok := byServer[server]
+		if !ok {
+			m = &cifs.ServerTimings{Server: server, HZ: t.HZ}
+			byServer[server] = m
+			merged = append(merged, m)
+		}
+		part := map[string]uint64{}
+		for _, c := range t.Commands {
+			name := timingName(c.Command)
+			part[name+"count"], part[name+"total"], part[name+"slow"] = c.Count, c.Total, c.SlowResponses
+			mc := commandTiming(m, c.Command)
+			if c.Count > 0 {
+				if mc.Count == 0 || c.Fastest < mc.Fastest {
+					mc.Fastest = c.Fastest
+				}
+				if c.Slowest > mc.Slowest {
+					mc.Slowest = c.Slowest
+				}
+			}
+			mc.Count += c.Count
+			mc.Total += c.Total
+			mc.SlowResponses += c.SlowResponses
+		}
+		parts[server] = append(parts[server], part)
+	}
+	return merged, parts
+}
+
+// commandTiming returns the timing of a command number in t, it adds a new one if t does not have it yet.
+func commandTiming(t *cifs.ServerTimings, number int) *cifs.CommandTiming {
+	for i := range t.Commands {
+		if t.Commands[i].Command == number {
+			return &t.Commands[i]
+		}
+	}
+	t.Commands = append(t.Commands, cifs.CommandTiming{Command: number})
+	return &t.Commands[len(t.Commands)-1]
+}
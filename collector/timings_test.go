@@ -0,0 +1,68 @@
+package collector
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shibumi/cifs-exporter/cifs"
+)
+
+func TestTimingsMergeConnections(t *testing.T) {
+	stats := &cifs.ClientStats{
+		Stats2: true,
+		Timings: []*cifs.ServerTimings{
+			{Server: "srv", HZ: 100, Commands: []cifs.CommandTiming{
+				{Command: 5, Count: 10, Total: 20, Fastest: 2, Slowest: 5, SlowResponses: 1},
+				{Command: 8, Count: 0},
+			}},
+			{Server: "other", HZ: 100, Commands: []cifs.CommandTiming{{Command: 5, Count: 1, Total: 1, Fastest: 1, Slowest: 1}}},
+			// A second connection to srv, for example of another user
+			{Server: "srv", HZ: 100, Commands: []cifs.CommandTiming{
+				{Command: 5, Count: 4, Total: 30, Fastest: 3, Slowest: 12},
+				{Command: 8, Count: 2, Total: 2, Fastest: 1, Slowest: 1},
+			}},
+			// A connection without blocks and slow responses, we don't know its server
+			{HZ: 100, Commands: []cifs.CommandTiming{{Command: 5, Count: 100, Total: 100, Fastest: 1, Slowest: 1}}},
+		},
+	}
+	m := newTimingMetrics()
+	got := collectMetrics(t, "cifs_command_", func(ch chan<- prometheus.Metric) {
+		m.collect(ch, stats, Config{}, newResetTracker())
+	})
+	want := []string{
+		`cifs_command_duration_seconds_total{command="create",server="other"} 0.01`,
+		`cifs_command_duration_seconds_total{command="create",server="srv"} 0.5`,
+		`cifs_command_duration_seconds_total{command="read",server="srv"} 0.02`,
+		`cifs_command_fastest_seconds{command="create",server="other"} 0.01`,
+		`cifs_command_fastest_seconds{command="create",server="srv"} 0.02`,
+		`cifs_command_fastest_seconds{command="read",server="srv"} 0.01`,
+		`cifs_command_requests_total{command="create",server="other"} 1`,
+		`cifs_command_requests_total{command="create",server="srv"} 14`,
+		`cifs_command_requests_total{command="read",server="srv"} 2`,
+		`cifs_command_slow_responses_total{command="create",server="other"} 0`,
+		`cifs_command_slow_responses_total{command="create",server="srv"} 1`,
+		`cifs_command_slow_responses_total{command="read",server="srv"} 0`,
+		`cifs_command_slowest_seconds{command="create",server="other"} 0.01`,
+		`cifs_command_slowest_seconds{command="create",server="srv"} 0.12`,
+		`cifs_command_slowest_seconds{command="read",server="srv"} 0.01`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	stats := &cifs.ClientStats{InFlight: []*cifs.ServerInFlight{{Server: "srv", MaxRequests: 3}, {MaxRequests: 9}, {Server: "other", MaxRequests: 1}, {Server: "srv", MaxRequests: 7}}}
+	got := collectMetrics(t, "cifs_max_requests_in_flight", func(ch chan<- prometheus.Metric) {
+		newTimingMetrics().collect(ch, stats, Config{}, newResetTracker())
+	})
+	want := []string{
+		`cifs_max_requests_in_flight{server="other"} 1`,
+		`cifs_max_requests_in_flight{server="srv"} 7`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,351 @@
+// Package config loads the YAML configuration file of the exporter.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shibumi/cifs-exporter/cifs"
+	"github.com/shibumi/cifs-exporter/collector"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the YAML configuration file. Every setting has a default, so an empty file is valid.
+type Config struct {
+	Web        WebConfig        `yaml:"web"`
+	Path       PathConfig       `yaml:"path"`
+	Collectors CollectorsConfig `yaml:"collectors"`
+	Metrics    MetricsConfig    `yaml:"metrics"`
+	Shares     SharesConfig     `yaml:"shares"`
+	MountCheck MountCheckConfig `yaml:"mount_check"`
+	// LabelFilters drops every series with a label whose value does not match the pattern, by label name.
+	LabelFilters map[string]string `yaml:"label_filters"`
+	// Modules are the probe modules for the /probe endpoint, by name.
+	Modules map[string]Module `yaml:"modules"`
+}
+
+// WebConfig holds the settings of the web server.
+type WebConfig struct {
+	// ListenAddresses are TCP addresses like :9965 or [::1]:9965 and Unix sockets like unix:/run/cifs-exporter.sock.
+	ListenAddresses []string `yaml:"listen_addresses"`
+	// UnixSocketMode are the octal permissions of Unix sockets, for example 0660.
+	UnixSocketMode string `yaml:"unix_socket_mode"`
+	// SystemdSocket uses the sockets passed by systemd socket activation instead of ListenAddresses.
+	SystemdSocket bool   `yaml:"systemd_socket"`
+	TelemetryPath string `yaml:"telemetry_path"`
+	// The timeouts of the HTTP server, 0 disables a timeout.
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+	// MaxRequests is the maximum number of parallel scrapes, further scrapes get a 503. 0 disables the limit.
+	MaxRequests int `yaml:"max_requests"`
+	// ShutdownTimeout is the time we wait for active requests on shutdown, 0 waits forever.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// ConfigFile is the web configuration file of the Prometheus exporter toolkit,
+	// which enables TLS and basic authentication.
+	ConfigFile string `yaml:"config_file"`
+	// EnableLifecycle enables POST /-/reload. Without it, only SIGHUP reloads the configuration.
+	EnableLifecycle bool `yaml:"enable_lifecycle"`
+}
+
+// SocketMode returns the permissions of Unix sockets.
+func (c WebConfig) SocketMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(c.UnixSocketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("%q is not an octal file mode like 0660", c.UnixSocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// PathConfig holds the paths the exporter reads from.
+type PathConfig struct {
+	Procfs string `yaml:"procfs"`
+	// Rootfs is the host's root, the mount checks and the probes look for the mount points below it.
+	Rootfs string `yaml:"rootfs"`
+}
+
+// CollectorsConfig enables and disables the optional parts of the collector.
+// The Stats file is always read.
+type CollectorsConfig struct {
+	DebugData      bool `yaml:"debugdata"`
+	Mounts         bool `yaml:"mounts"`
+	ShareConsumers bool `yaml:"share_consumers"`
+	OpenFiles      bool `yaml:"open_files"`
+	// OpenFilesByProcess and OpenFilesByFile export the open files per process and per file,
+	// which may be a lot of series.
+	OpenFilesByProcess bool `yaml:"open_files_by_process"`
+	OpenFilesByFile    bool `yaml:"open_files_by_file"`
+	OpenDirs           bool `yaml:"open_dirs"`
+}
+
+// MetricsConfig holds the settings of the exported metrics.
+type MetricsConfig struct {
+	LegacyNames        bool `yaml:"legacy_names"`
+	AccumulateCounters bool `yaml:"accumulate_counters"`
+}
+
+// SharesConfig selects the shares we export. The patterns are regular expressions and have
+// to match the whole server/share, for example fileserver\.example\.com/data.
+// If Include is empty every share is included, Exclude wins over Include.
+type SharesConfig struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// MountCheckConfig holds the settings of the background statfs calls on every CIFS mount.
+type MountCheckConfig struct {
+	// Interval is the time between two checks of a mount, 0 disables the checks.
+	Interval time.Duration `yaml:"interval"`
+	// Timeout is the time after which a hanging check marks the mount as stale.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// The probers a module can use.
+const (
+	ProberStat  = "stat"
+	ProberList  = "list"
+	ProberRead  = "read"
+	ProberWrite = "write"
+)
+
+// Module is a probe definition.
+type Module struct {
+	Prober  string        `yaml:"prober"`
+	Timeout time.Duration `yaml:"timeout"`
+	// File is the file below the probed path the read and write probers use.
+	File string `yaml:"file"`
+	// Size is the number of bytes the write prober writes and the read prober reads at most.
+	Size int64 `yaml:"size"`
+	// AnyTarget allows targets which are not on a CIFS mount, for example a local directory.
+	// Without it, the target has to be a CIFS mount point or a directory below one.
+	AnyTarget bool `yaml:"any_target"`
+}
+
+// Defaults for the settings which are not in the configuration file.
+const (
+	DefaultListenAddress   = ":9965"
+	DefaultUnixSocketMode  = "0660"
+	DefaultTelemetryPath   = "/metrics"
+	DefaultReadTimeout     = 10 * time.Second
+	DefaultWriteTimeout    = time.Minute
+	DefaultIdleTimeout     = 2 * time.Minute
+	DefaultMaxRequests     = 40
+	DefaultShutdownTimeout = 30 * time.Second
+	DefaultRootfs          = "/"
+	DefaultCheckInterval   = 15 * time.Second
+	DefaultCheckTimeout    = 5 * time.Second
+	DefaultProbeTimeout    = 5 * time.Second
+	DefaultProbeFile       = ".cifs-exporter-probe"
+	DefaultProbeSize       = 4096
+)
+
+// Default returns the configuration we use without a configuration file.
+func Default() *Config {
+	return &Config{
+		Web: WebConfig{
+			ListenAddresses: []string{DefaultListenAddress},
+			UnixSocketMode:  DefaultUnixSocketMode,
+			TelemetryPath:   DefaultTelemetryPath,
+			ReadTimeout:     DefaultReadTimeout,
+			WriteTimeout:    DefaultWriteTimeout,
+			IdleTimeout:     DefaultIdleTimeout,
+			MaxRequests:     DefaultMaxRequests,
+			ShutdownTimeout: DefaultShutdownTimeout,
+		},
+		Path: PathConfig{
+			Procfs: cifs.DefaultProcMountPoint,
+			Rootfs: DefaultRootfs,
+		},
+		Collectors: CollectorsConfig{
+			DebugData: true,
+			Mounts:    true,
+			OpenFiles: true,
+			OpenDirs:  true,
+		},
+		MountCheck: MountCheckConfig{
+			Interval: DefaultCheckInterval,
+			Timeout:  DefaultCheckTimeout,
+		},
+	}
+}
+
+// Load parses a YAML configuration and validates it. Unknown fields are an error,
+// so typos don't go unnoticed.
+func Load(s string) (*Config, error) {
+	c := Default()
+	if err := yaml.UnmarshalStrict([]byte(s), c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadFile reads the configuration file at filename.
+func LoadFile(filename string) (*Config, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Load(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("could not load %s: %w", filename, err)
+	}
+	return c, nil
+}
+
+// Validate checks the configuration and sets the defaults of the probe modules.
+// It returns the first error it finds.
+func (c *Config) Validate() error {
+	if len(c.Web.ListenAddresses) == 0 && !c.Web.SystemdSocket {
+		return fmt.Errorf("web.listen_addresses: must not be empty")
+	}
+	for i, address := range c.Web.ListenAddresses {
+		if address == "" || address == "unix:" {
+			return fmt.Errorf("web.listen_addresses[%d]: must not be empty", i)
+		}
+	}
+	if _, err := c.Web.SocketMode(); err != nil {
+		return fmt.Errorf("web.unix_socket_mode: %w", err)
+	}
+	if !strings.HasPrefix(c.Web.TelemetryPath, "/") {
+		return fmt.Errorf("web.telemetry_path: %q must start with /", c.Web.TelemetryPath)
+	}
+	for name, d := range map[string]time.Duration{
+		"read_timeout":     c.Web.ReadTimeout,
+		"write_timeout":    c.Web.WriteTimeout,
+		"idle_timeout":     c.Web.IdleTimeout,
+		"shutdown_timeout": c.Web.ShutdownTimeout,
+	} {
+		if d < 0 {
+			return fmt.Errorf("web.%s: must not be negative", name)
+		}
+	}
+	if c.Web.MaxRequests < 0 {
+		return fmt.Errorf("web.max_requests: must not be negative")
+	}
+	if c.Path.Procfs == "" {
+		return fmt.Errorf("path.procfs: must not be empty")
+	}
+	if c.Path.Rootfs == "" {
+		return fmt.Errorf("path.rootfs: must not be empty")
+	}
+	if c.MountCheck.Interval < 0 {
+		return fmt.Errorf("mount_check.interval: must not be negative")
+	}
+	if c.MountCheck.Timeout <= 0 {
+		return fmt.Errorf("mount_check.timeout: must be positive")
+	}
+	for i, p := range c.Shares.Include {
+		if _, err := compile(p); err != nil {
+			return fmt.Errorf("shares.include[%d]: %w", i, err)
+		}
+	}
+	for i, p := range c.Shares.Exclude {
+		if _, err := compile(p); err != nil {
+			return fmt.Errorf("shares.exclude[%d]: %w", i, err)
+		}
+	}
+	for name, p := range c.LabelFilters {
+		if name == "" {
+			return fmt.Errorf("label_filters: label name must not be empty")
+		}
+		if _, err := compile(p); err != nil {
+			return fmt.Errorf("label_filters.%s: %w", name, err)
+		}
+	}
+	for name, m := range c.Modules {
+		if err := m.validate(); err != nil {
+			return fmt.Errorf("modules.%s: %w", name, err)
+		}
+		c.Modules[name] = m
+	}
+	return nil
+}
+
+// validate checks a module and sets its defaults.
+func (m *Module) validate() error {
+	switch m.Prober {
+	case ProberStat, ProberList, ProberRead, ProberWrite:
+	case "":
+		return fmt.Errorf("prober: must be one of %s, %s, %s or %s", ProberStat, ProberList, ProberRead, ProberWrite)
+	default:
+		return fmt.Errorf("prober: unknown prober %q, must be one of %s, %s, %s or %s", m.Prober, ProberStat, ProberList, ProberRead, ProberWrite)
+	}
+	if m.Timeout < 0 {
+		return fmt.Errorf("timeout: must not be negative")
+	}
+	if m.Timeout == 0 {
+		m.Timeout = DefaultProbeTimeout
+	}
+	if m.Size < 0 {
+		return fmt.Errorf("size: must not be negative")
+	}
+	if m.Size == 0 {
+		m.Size = DefaultProbeSize
+	}
+	if m.Prober == ProberRead && m.File == "" {
+		return fmt.Errorf("file: the read prober needs a file")
+	}
+	if m.File == "" {
+		m.File = DefaultProbeFile
+	}
+	if path.IsAbs(m.File) || strings.HasPrefix(path.Clean(m.File), "..") {
+		return fmt.Errorf("file: %q must be relative to the probed path", m.File)
+	}
+	return nil
+}
+
+// compile compiles an anchored regular expression, like Prometheus does in relabel configs.
+func compile(pattern string) (*regexp.Regexp, error) {
+	// We compile the pattern on its own first, so errors show the pattern of the user
+	if _, err := regexp.Compile(pattern); err != nil {
+		return nil, err
+	}
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// mustCompile compiles a list of patterns, which have been checked by Validate before.
+func mustCompile(patterns []string) []*regexp.Regexp {
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := compile(p)
+		if err != nil {
+			panic(err)
+		}
+		res = append(res, re)
+	}
+	return res
+}
+
+// CollectorConfig returns the settings of the CIFSCollector. The configuration has to be valid.
+func (c *Config) CollectorConfig() collector.Config {
+	filters := map[string]*regexp.Regexp{}
+	for name, p := range c.LabelFilters {
+		filters[name] = mustCompile([]string{p})[0]
+	}
+	return collector.Config{
+		LegacyNames:        c.Metrics.LegacyNames,
+		Accumulate:         c.Metrics.AccumulateCounters,
+		DebugData:          c.Collectors.DebugData,
+		Mounts:             c.Collectors.Mounts,
+		Consumers:          c.Collectors.ShareConsumers,
+		OpenFiles:          c.Collectors.OpenFiles,
+		OpenFilesByProcess: c.Collectors.OpenFilesByProcess,
+		OpenFilesByFile:    c.Collectors.OpenFilesByFile,
+		OpenDirs:           c.Collectors.OpenDirs,
+		ShareInclude:       mustCompile(c.Shares.Include),
+		ShareExclude:       mustCompile(c.Shares.Exclude),
+		LabelFilters:       filters,
+		MountCheckInterval: c.MountCheck.Interval,
+		MountCheckTimeout:  c.MountCheck.Timeout,
+		RootFS:             c.Path.Rootfs,
+	}
+}
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadDefaults(t *testing.T) {
+	for _, content := range []string{"", "{}", "web: {}\n"} {
+		c, err := Load(content)
+		if err != nil {
+			t.Fatalf("Load(%q): %v", content, err)
+		}
+		if !reflect.DeepEqual(c, Default()) {
+			t.Errorf("Load(%q) = %+v, want the defaults %+v", content, c, Default())
+		}
+	}
+	c := Default()
+	if !reflect.DeepEqual(c.Web.ListenAddresses, []string{":9965"}) || c.Web.TelemetryPath != "/metrics" || c.Web.MaxRequests != 40 {
+		t.Errorf("web defaults = %+v", c.Web)
+	}
+	if !c.Collectors.DebugData || !c.Collectors.Mounts || !c.Collectors.OpenFiles || c.Collectors.ShareConsumers {
+		t.Errorf("collector defaults = %+v", c.Collectors)
+	}
+	if c.Path.Procfs != "/proc" || c.Path.Rootfs != "/" {
+		t.Errorf("path defaults = %+v", c.Path)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	c, err := Load(`
+web:
+  listen_addresses: [":9100", "unix:/run/cifs.sock"]
+  read_timeout: 3s
+metrics:
+  legacy_names: true
+collectors:
+  open_dirs: false
+mount_check:
+  interval: 0s
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.Web.ListenAddresses, []string{":9100", "unix:/run/cifs.sock"}) {
+		t.Errorf("listen addresses = %v", c.Web.ListenAddresses)
+	}
+	if c.Web.ReadTimeout != 3*time.Second || c.Web.WriteTimeout != DefaultWriteTimeout {
+		t.Errorf("timeouts = %s, %s", c.Web.ReadTimeout, c.Web.WriteTimeout)
+	}
+	if !c.Metrics.LegacyNames || c.Collectors.OpenDirs || !c.Collectors.OpenFiles {
+		t.Errorf("metrics = %+v, collectors = %+v", c.Metrics, c.Collectors)
+	}
+	if c.MountCheck.Interval != 0 || c.MountCheck.Timeout != DefaultCheckTimeout {
+		t.Errorf("mount check = %+v", c.MountCheck)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"unknown top level field", "webb: {}\n", "field webb not found"},
+		{"unknown nested field", "web:\n  listen_adress: [':1']\n", "field listen_adress not found"},
+		{"unknown module field", "modules:\n  m:\n    prober: stat\n    timout: 1s\n", "field timout not found"},
+		{"wrong type", "web:\n  max_requests: many\n", "cannot unmarshal"},
+		{"no listen address", "web:\n  listen_addresses: []\n", "web.listen_addresses: must not be empty"},
+		{"empty unix socket", "web:\n  listen_addresses: ['unix:']\n", "web.listen_addresses[0]: must not be empty"},
+		{"socket mode", "web:\n  unix_socket_mode: rw\n", "web.unix_socket_mode"},
+		{"socket mode too large", "web:\n  unix_socket_mode: '1777'\n", "web.unix_socket_mode"},
+		{"telemetry path", "web:\n  telemetry_path: metrics\n", "web.telemetry_path"},
+		{"negative timeout", "web:\n  idle_timeout: -1s\n", "web.idle_timeout: must not be negative"},
+		{"negative max requests", "web:\n  max_requests: -1\n", "web.max_requests"},
+		{"empty procfs", "path:\n  procfs: ''\n", "path.procfs"},
+		{"check timeout", "mount_check:\n  timeout: 0s\n", "mount_check.timeout: must be positive"},
+		{"include regex", "shares:\n  include: ['srv/(data']\n", "shares.include[0]: error parsing regexp"},
+		{"exclude regex", "shares:\n  exclude: ['ok', '*']\n", "shares.exclude[1]: error parsing regexp"},
+		{"label filter regex", "label_filters:\n  server: '[a-'\n", "label_filters.server: error parsing regexp"},
+		{"empty label name", "label_filters:\n  '': x\n", "label name must not be empty"},
+		{"missing prober", "modules:\n  m:\n    timeout: 1s\n", "modules.m: prober: must be one of"},
+		{"unknown prober", "modules:\n  m:\n    prober: ping\n", `modules.m: prober: unknown prober "ping"`},
+		{"read without file", "modules:\n  m:\n    prober: read\n", "modules.m: file: the read prober needs a file"},
+		{"absolute file", "modules:\n  m:\n    prober: write\n    file: /etc/passwd\n", "must be relative"},
+		{"file outside", "modules:\n  m:\n    prober: write\n    file: a/../../x\n", "must be relative"},
+		{"negative size", "modules:\n  m:\n    prober: write\n    size: -1\n", "modules.m: size"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Load(test.content)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("error = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestModuleDefaults(t *testing.T) {
+	c, err := Load(`
+modules:
+  stat:
+    prober: stat
+  read:
+    prober: read
+    file: dir/canary.txt
+    size: 10
+    timeout: 2s
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Module{
+		"stat": {Prober: ProberStat, Timeout: DefaultProbeTimeout, File: DefaultProbeFile, Size: DefaultProbeSize},
+		"read": {Prober: ProberRead, Timeout: 2 * time.Second, File: "dir/canary.txt", Size: 10},
+	}
+	if !reflect.DeepEqual(c.Modules, want) {
+		t.Errorf("modules = %+v, want %+v", c.Modules, want)
+	}
+}
+
+func TestCollectorConfig(t *testing.T) {
+	c, err := Load(`
+shares:
+  include: ['srv/.*']
+  exclude: ['srv/data']
+label_filters:
+  share: 'da.a'
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := c.CollectorConfig()
+	// The patterns are anchored
+	tests := []struct {
+		name  string
+		match bool
+		got   bool
+	}{
+		{"include", true, cc.ShareInclude[0].MatchString("srv/home")},
+		{"include other server", false, cc.ShareInclude[0].MatchString("other/srv/home")},
+		{"exclude", true, cc.ShareExclude[0].MatchString("srv/data")},
+		{"exclude prefix", false, cc.ShareExclude[0].MatchString("srv/data2")},
+		{"label filter", true, cc.LabelFilters["share"].MatchString("data")},
+		{"label filter suffix", false, cc.LabelFilters["share"].MatchString("metadata")},
+	}
+	for _, test := range tests {
+		if test.got != test.match {
+			t.Errorf("%s: match = %v, want %v", test.name, test.got, test.match)
+		}
+	}
+	if !cc.DebugData || cc.Accumulate || cc.MountCheckInterval != DefaultCheckInterval || cc.RootFS != "/" {
+		t.Errorf("collector config = %+v", cc)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := LoadFile(filepath.Join(dir, "missing.yml")); !os.IsNotExist(err) {
+		t.Errorf("error = %v, want not exist", err)
+	}
+	name := filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(name, []byte("web:\n  telemetry_path: x\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFile(name); err == nil || !strings.Contains(err.Error(), name) {
+		t.Errorf("error = %v, want the file name", err)
+	}
+	if _, err := LoadFile(filepath.Join("..", "examples", "config.yml")); err != nil {
+		t.Errorf("example configuration: %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/exporter-toolkit/web"
+	"github.com/shibumi/cifs-exporter/cifs"
+	"github.com/shibumi/cifs-exporter/config"
+)
+
+// flags holds the command line flags. Flags set on the command line win over the configuration file.
+type flags struct {
+	set                *flag.FlagSet
+	configFile         *string
+	configCheck        *bool
+	listenAddrs        stringsFlag
+	socketMode         *string
+	systemdSocket      *bool
+	webConfig          *string
+	enableLifecycle    *bool
+	readTimeout        *time.Duration
+	writeTimeout       *time.Duration
+	idleTimeout        *time.Duration
+	maxRequests        *int
+	shutdownTimeout    *time.Duration
+	metricsPath        *string
+	procPath           *string
+	rootPath           *string
+	legacyNames        *bool
+	accumulate         *bool
+	checkInterval      *time.Duration
+	checkTimeout       *time.Duration
+	openFilesByProcess *bool
+	openFilesByFile    *bool
+	consumers          *bool
+	version            *bool
+}
+
+// newFlags defines all flags on set.
+func newFlags(set *flag.FlagSet) *flags {
+	f := &flags{set: set}
+	f.configFile = set.String("config.file", "", "Path to the YAML configuration file.")
+	f.configCheck = set.Bool("config.check", false, "Validate the configuration and exit.")
+	set.Var(&f.listenAddrs, "web.listen-address", "`Address` to listen on for web interface and telemetry, can be repeated. Use unix:/path for a Unix socket. (default \""+config.DefaultListenAddress+"\")")
+	f.socketMode = set.String("web.unix-socket-mode", config.DefaultUnixSocketMode, "Octal permissions of Unix sockets.")
+	f.systemdSocket = set.Bool("web.systemd-socket", false, "Use the sockets of systemd socket activation instead of the listen addresses.")
+	f.webConfig = set.String("web.config.file", "", "Path to the web configuration file, which can enable TLS and basic authentication.")
+	f.enableLifecycle = set.Bool("web.enable-lifecycle", false, "Enable the configuration reload via HTTP POST to /-/reload.")
+	f.readTimeout = set.Duration("web.read-timeout", config.DefaultReadTimeout, "Maximum duration for reading a request, 0 disables the timeout.")
+	f.writeTimeout = set.Duration("web.write-timeout", config.DefaultWriteTimeout, "Maximum duration for writing a response, 0 disables the timeout.")
+	f.idleTimeout = set.Duration("web.idle-timeout", config.DefaultIdleTimeout, "Maximum duration to wait for the next request on a keep-alive connection, 0 disables the timeout.")
+	f.maxRequests = set.Int("web.max-requests", config.DefaultMaxRequests, "Maximum number of parallel scrapes, further scrapes get a 503. 0 disables the limit.")
+	f.shutdownTimeout = set.Duration("web.shutdown-timeout", config.DefaultShutdownTimeout, "Maximum duration to wait for active requests on SIGINT or SIGTERM, 0 waits forever.")
+	f.metricsPath = set.String("web.telemetry-path", config.DefaultTelemetryPath, "A path under which to expose metrics.")
+	f.procPath = set.String("path.procfs", cifs.DefaultProcMountPoint, "procfs mountpoint.")
+	f.rootPath = set.String("path.rootfs", config.DefaultRootfs, "Path of the host's root, the mount checks and the probes look for the CIFS mount points below it.")
+	f.legacyNames = set.Bool("metrics.legacy-names", false, "Also export the Stats metrics under their old gauge names, for example cifs_total_reads.")
+	f.accumulate = set.Bool("metrics.accumulate-counters", false, "Accumulate the Stats counters over resets of /proc/fs/cifs/Stats, so they never go backwards.")
+	f.checkInterval = set.Duration("collector.mount-check.interval", config.DefaultCheckInterval, "Interval of the background statfs calls on every CIFS mount, 0 disables them.")
+	f.checkTimeout = set.Duration("collector.mount-check.timeout", config.DefaultCheckTimeout, "Time after which a hanging statfs call marks a CIFS mount as stale.")
+	f.openFilesByProcess = set.Bool("collector.open-files.by-process", false, "Export the open file handles per process and user, not only per share.")
+	f.openFilesByFile = set.Bool("collector.open-files.by-file", false, "Export the open file handles per process, user and filename. This may be a lot of series.")
+	f.consumers = set.Bool("collector.share-consumers", false, "Export the mount namespaces and cgroups using every share. Reads the mountinfo of every process, needs the host's PID namespace.")
+	f.version = set.Bool("version", false, "Display version information")
+	return f
+}
+
+// apply overrides the settings of cfg with every flag set on the command line.
+func (f *flags) apply(cfg *config.Config) {
+	f.set.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "web.listen-address":
+			cfg.Web.ListenAddresses = f.listenAddrs
+		case "web.unix-socket-mode":
+			cfg.Web.UnixSocketMode = *f.socketMode
+		case "web.systemd-socket":
+			cfg.Web.SystemdSocket = *f.systemdSocket
+		case "web.config.file":
+			cfg.Web.ConfigFile = *f.webConfig
+		case "web.enable-lifecycle":
+			cfg.Web.EnableLifecycle = *f.enableLifecycle
+		case "web.read-timeout":
+			cfg.Web.ReadTimeout = *f.readTimeout
+		case "web.write-timeout":
+			cfg.Web.WriteTimeout = *f.writeTimeout
+		case "web.idle-timeout":
+			cfg.Web.IdleTimeout = *f.idleTimeout
+		case "web.max-requests":
+			cfg.Web.MaxRequests = *f.maxRequests
+		case "web.shutdown-timeout":
+			cfg.Web.ShutdownTimeout = *f.shutdownTimeout
+		case "web.telemetry-path":
+			cfg.Web.TelemetryPath = *f.metricsPath
+		case "path.procfs":
+			cfg.Path.Procfs = *f.procPath
+		case "path.rootfs":
+			cfg.Path.Rootfs = *f.rootPath
+		case "metrics.legacy-names":
+			cfg.Metrics.LegacyNames = *f.legacyNames
+		case "metrics.accumulate-counters":
+			cfg.Metrics.AccumulateCounters = *f.accumulate
+		case "collector.mount-check.interval":
+			cfg.MountCheck.Interval = *f.checkInterval
+		case "collector.mount-check.timeout":
+			cfg.MountCheck.Timeout = *f.checkTimeout
+		case "collector.open-files.by-process":
+			cfg.Collectors.OpenFilesByProcess = *f.openFilesByProcess
+		case "collector.open-files.by-file":
+			cfg.Collectors.OpenFilesByFile = *f.openFilesByFile
+		case "collector.share-consumers":
+			cfg.Collectors.ShareConsumers = *f.consumers
+		}
+	})
+}
+
+// loadConfig reads the configuration file, if there is one, and applies the flags.
+func (f *flags) loadConfig() (*config.Config, error) {
+	cfg := config.Default()
+	if *f.configFile != "" {
+		var err error
+		if cfg, err = config.LoadFile(*f.configFile); err != nil {
+			return nil, err
+		}
+	}
+	f.apply(cfg)
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := web.Validate(cfg.Web.ConfigFile); err != nil {
+		return nil, fmt.Errorf("invalid web configuration %s: %w", cfg.Web.ConfigFile, err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/shibumi/cifs-exporter/config"
+)
+
+// parseFlags parses args like the command line.
+func parseFlags(t *testing.T, args ...string) *flags {
+	t.Helper()
+	set := flag.NewFlagSet("cifs-exporter", flag.ContinueOnError)
+	set.SetOutput(ioutil.Discard)
+	f := newFlags(set)
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestFlagsWinOverConfigFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "config.yml")
+	writeFile(t, name, `
+web:
+  listen_addresses: [":9100"]
+  max_requests: 5
+  read_timeout: 3s
+metrics:
+  legacy_names: true
+mount_check:
+  interval: 1m
+`)
+	cfg, err := parseFlags(t, "-config.file", name,
+		"-web.listen-address", "127.0.0.1:9965", "-web.listen-address", "unix:/run/cifs.sock",
+		"-metrics.legacy-names=false", "-collector.mount-check.interval", "0s", "-web.read-timeout", "10s",
+	).loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"127.0.0.1:9965", "unix:/run/cifs.sock"}; !reflect.DeepEqual(cfg.Web.ListenAddresses, want) {
+		t.Errorf("listen addresses = %v, want %v", cfg.Web.ListenAddresses, want)
+	}
+	if cfg.Metrics.LegacyNames {
+		t.Error("legacy names enabled, the flag disabled them")
+	}
+	if cfg.MountCheck.Interval != 0 {
+		t.Errorf("mount check interval = %s, want 0", cfg.MountCheck.Interval)
+	}
+	// The flag is set to its default value, it still wins
+	if cfg.Web.ReadTimeout != config.DefaultReadTimeout {
+		t.Errorf("read timeout = %s, want %s", cfg.Web.ReadTimeout, config.DefaultReadTimeout)
+	}
+	// Flags which are not set don't touch the file's settings
+	if cfg.Web.MaxRequests != 5 {
+		t.Errorf("max requests = %d, want 5 from the file", cfg.Web.MaxRequests)
+	}
+}
+
+func TestFlagsWithoutConfigFile(t *testing.T) {
+	cfg, err := parseFlags(t, "-web.max-requests", "3", "-collector.share-consumers").loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := config.Default()
+	want.Web.MaxRequests = 3
+	want.Collectors.ShareConsumers = true
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("config = %+v, want %+v", cfg, want)
+	}
+}
+
+func TestFlagsInvalid(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "config.yml")
+	writeFile(t, name, "mount_check:\n  timeout: 1s\n")
+	// The flags are validated together with the file
+	if _, err := parseFlags(t, "-config.file", name, "-collector.mount-check.timeout", "0s").loadConfig(); err == nil {
+		t.Error("zero mount check timeout accepted")
+	}
+	if _, err := parseFlags(t, "-web.config.file", filepath.Join(t.TempDir(), "missing.yml")).loadConfig(); err == nil {
+		t.Error("missing web configuration accepted")
+	}
+	if cfg, err := parseFlags(t, "-config.file", name, "-web.shutdown-timeout", "1m").loadConfig(); err != nil || cfg.Web.ShutdownTimeout != time.Minute {
+		t.Errorf("shutdown timeout = %v, %v", cfg, err)
+	}
+}
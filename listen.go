@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/activation"
+)
+
+// unixPrefix marks listen addresses of Unix sockets, for example unix:/run/cifs-exporter.sock.
+const unixPrefix = "unix:"
+
+// stringsFlag is a flag which can be repeated, every value is appended.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// listen opens a listener for every address. TCP addresses listen on IPv4 and IPv6, unless
+// they name an address of one of them, for example 0.0.0.0:9965 or [::1]:9965.
+// Unix sockets get the permissions socketMode. With systemdSocket we use the sockets
+// systemd passed us instead of the addresses. Repeated addresses are an error, the second
+// listener of a Unix socket would remove the socket of the first one.
+func listen(addresses []string, socketMode os.FileMode, systemdSocket bool) ([]net.Listener, error) {
+	if systemdSocket {
+		listeners, err := activation.Listeners()
+		if err != nil {
+			return nil, fmt.Errorf("could not use systemd sockets: %w", err)
+		}
+		if len(listeners) == 0 {
+			return nil, fmt.Errorf("systemd did not pass any socket")
+		}
+		return listeners, nil
+	}
+	seen := map[string]bool{}
+	for _, address := range addresses {
+		if seen[address] {
+			return nil, fmt.Errorf("listen address %s is given more than once", address)
+		}
+		seen[address] = true
+	}
+	var listeners []net.Listener
+	for _, address := range addresses {
+		l, err := listenAddress(address, socketMode)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+func listenAddress(address string, socketMode os.FileMode) (net.Listener, error) {
+	if !strings.HasPrefix(address, unixPrefix) {
+		return net.Listen("tcp", address)
+	}
+	path := strings.TrimPrefix(address, unixPrefix)
+	// Remove the socket of an earlier run, but never anything else
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, socketMode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// closeAll closes the listeners at the end of the test.
+func closeAll(t *testing.T, listeners []net.Listener) {
+	t.Cleanup(func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	})
+}
+
+func TestListen(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "cifs.sock")
+	listeners, err := listen([]string{"127.0.0.1:0", unixPrefix + socket}, 0600, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeAll(t, listeners)
+	if len(listeners) != 2 {
+		t.Fatalf("got %d listeners, want 2", len(listeners))
+	}
+	if network := listeners[0].Addr().Network(); network != "tcp" {
+		t.Errorf("first listener is %s, want tcp", network)
+	}
+	if network := listeners[1].Addr().Network(); network != "unix" {
+		t.Errorf("second listener is %s, want unix", network)
+	}
+	for _, l := range listeners {
+		conn, err := net.Dial(l.Addr().Network(), l.Addr().String())
+		if err != nil {
+			t.Errorf("could not connect to %s: %v", l.Addr(), err)
+			continue
+		}
+		conn.Close()
+	}
+}
+
+func TestListenSocketMode(t *testing.T) {
+	for _, mode := range []os.FileMode{0600, 0660, 0666} {
+		socket := filepath.Join(t.TempDir(), "cifs.sock")
+		l, err := listenAddress(unixPrefix+socket, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		closeAll(t, []net.Listener{l})
+		info, err := os.Stat(socket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != mode {
+			t.Errorf("socket has mode %v, want %v", info.Mode(), mode)
+		}
+	}
+}
+
+func TestListenStaleSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "cifs.sock")
+	// A socket of an earlier run, which did not remove it
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := listenAddress(unixPrefix+socket, 0600)
+	if err != nil {
+		t.Fatalf("stale socket was not replaced: %v", err)
+	}
+	closeAll(t, []net.Listener{l})
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestListenKeepsOtherFiles(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "cifs.sock")
+	writeFile(t, name, "important")
+	if l, err := listenAddress(unixPrefix+name, 0600); err == nil {
+		l.Close()
+		t.Fatal("listened on a regular file")
+	}
+	if data, err := os.ReadFile(name); err != nil || string(data) != "important" {
+		t.Errorf("regular file was changed: %q, %v", data, err)
+	}
+}
+
+func TestListenRepeatedAddress(t *testing.T) {
+	socket := unixPrefix + filepath.Join(t.TempDir(), "cifs.sock")
+	for _, addresses := range [][]string{
+		{socket, socket},
+		{"127.0.0.1:0", "127.0.0.1:0"},
+	} {
+		listeners, err := listen(addresses, 0600, false)
+		if err == nil {
+			closeAll(t, listeners)
+			t.Errorf("no error for %v", addresses)
+			continue
+		}
+		if !strings.Contains(err.Error(), "more than once") {
+			t.Errorf("unexpected error for %v: %v", addresses, err)
+		}
+	}
+}
+
+func TestListenClosesOnError(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "cifs.sock")
+	_, err := listen([]string{unixPrefix + socket, unixPrefix + filepath.Join(dir, "missing", "cifs.sock")}, 0600, false)
+	if err == nil {
+		t.Fatal("no error for a socket in a missing directory")
+	}
+	// The first listener was closed again, which removes its socket
+	if _, err := os.Lstat(socket); !os.IsNotExist(err) {
+		t.Errorf("socket of the first listener is still there: %v", err)
+	}
+}
+
+func TestStringsFlag(t *testing.T) {
+	var s stringsFlag
+	for _, value := range []string{":9965", "unix:/run/cifs.sock"} {
+		if err := s.Set(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := s.String(); got != ":9965, unix:/run/cifs.sock" {
+		t.Errorf("got %q", got)
+	}
+}
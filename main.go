@@ -2,12 +2,13 @@ package main
 
 import (
 	"flag"
+	kitlog "github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/shibumi/cifs-exporter/cifs"
 	"github.com/shibumi/cifs-exporter/collector"
 	"log"
-	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -16,25 +17,46 @@ import (
 var version, commit, date string
 
 func main() {
-	listenAddr := flag.String("web.listen-address", ":9965", "Address to listen on for web interface and telemetry.")
-	metricsPath := flag.String("web.telemetry-path", "/metrics", "A path under which to expose metrics.")
-	appVersion := flag.Bool("version", false, "Display version information")
+	f := newFlags(flag.CommandLine)
 	flag.Parse()
-	if *appVersion {
+	if *f.version {
 		println(filepath.Base(os.Args[0]), version, commit, date)
 		os.Exit(0)
 	}
+
+	cfg, err := f.loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *f.configCheck {
+		log.Printf("Configuration is valid")
+		os.Exit(0)
+	}
 	registry := prometheus.NewRegistry()
 
-	stats, _ := cifs.NewClientStats()
-	log.Println(stats)
-	http.Handle(*metricsPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	fs, err := cifs.NewFS(cfg.Path.Procfs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stats, err := fs.ClientStats()
+	if err != nil {
+		log.Printf("Could not read CIFS statistics: %v", err)
+	} else {
+		log.Printf("Found %d CIFS shares", len(stats.Blocks))
+		for _, w := range stats.Warnings {
+			log.Printf("Could not parse CIFS statistics: %v", w)
+		}
+	}
+	http.Handle(cfg.Web.TelemetryPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+		ErrorLog:            log.Default(),
+		MaxRequestsInFlight: cfg.Web.MaxRequests,
+	}))
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		_, err := w.Write([]byte(`<html>
 			<head><title>CIFS Exporter</title></head>
 			<body>
 			<h1>CIFS Exporter</h1>
-			<p><a href='` + *metricsPath + `'>Metrics</a></p>
+			<p><a href='` + cfg.Web.TelemetryPath + `'>Metrics</a></p>
 			</body>
 			</html>`))
 		if err != nil {
@@ -42,13 +64,34 @@ func main() {
 		}
 	})
 
-	registry.MustRegister(collector.NewCIFSCollector())
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	cifsCollector := collector.NewCIFSCollector(fs, cfg.CollectorConfig())
+	registry.MustRegister(cifsCollector)
+	go cifsCollector.CheckMounts()
+	reloader := newReloader(cfg, f.loadConfig, cifsCollector)
+	registry.MustRegister(reloader)
+	go reloader.watchSignals()
+	if cfg.Web.EnableLifecycle {
+		http.HandleFunc("/-/reload", reloader.handle)
+	} else {
+		http.HandleFunc("/-/reload", lifecycleDisabled)
+	}
+	http.Handle("/probe", newProber(fs, reloader.config))
 
-	srv := &http.Server{}
-	listener, err := net.Listen("tcp4", *listenAddr)
+	mode, _ := cfg.Web.SocketMode()
+	listeners, err := listen(cfg.Web.ListenAddresses, mode, cfg.Web.SystemdSocket)
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Printf("Providing metrics at %s%s", *listenAddr, *metricsPath)
-	log.Fatal(srv.Serve(listener))
+	logger := kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(os.Stderr))
+	for _, l := range listeners {
+		log.Printf("Providing metrics at %s%s", l.Addr(), cfg.Web.TelemetryPath)
+	}
+	if err := serve(listeners, cfg.Web, logger); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Shut down")
 }
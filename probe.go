@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/shibumi/cifs-exporter/cifs"
+	"github.com/shibumi/cifs-exporter/config"
+)
+
+// The phases of every prober, in the order they run.
+var proberPhases = map[string][]string{
+	config.ProberStat:  {"stat"},
+	config.ProberList:  {"stat", "list"},
+	config.ProberRead:  {"stat", "open", "read", "close"},
+	config.ProberWrite: {"stat", "create", "write", "fsync", "close", "delete"},
+}
+
+// The error classes we export with probe_error, so alerts can tell a hanging server from a full disk.
+const (
+	errorClassTimeout          = "timeout"
+	errorClassNotFound         = "not_found"
+	errorClassPermissionDenied = "permission_denied"
+	errorClassNotADirectory    = "not_a_directory"
+	errorClassReadOnly         = "read_only"
+	errorClassNoSpace          = "no_space"
+	errorClassStale            = "stale"
+	errorClassHostDown         = "host_down"
+	errorClassIO               = "io_error"
+	errorClassOther            = "other"
+)
+
+var errorClasses = []string{
+	errorClassTimeout, errorClassNotFound, errorClassPermissionDenied, errorClassNotADirectory, errorClassReadOnly,
+	errorClassNoSpace, errorClassStale, errorClassHostDown, errorClassIO, errorClassOther,
+}
+
+// scrapeTimeoutOffset is subtracted from the scrape timeout Prometheus sends us,
+// so we answer before Prometheus gives up.
+const scrapeTimeoutOffset = 500 * time.Millisecond
+
+// errNotADirectory is returned if the probe target is not a directory.
+var errNotADirectory = errors.New("not a directory")
+
+// errOutside is returned if the target or the probe file leads out of the mount with a symlink.
+var errOutside = fmt.Errorf("symlink leads outside of the probed mount: %w", os.ErrPermission)
+
+// prober serves /probe?target=<mountpoint>&module=<name>. A probe accesses the target like a
+// user of the share would, so it notices dead shares even if nobody uses them.
+// Anybody who can reach the exporter can start probes, so the target has to be on a CIFS mount,
+// unless the module allows any target, and a probe never follows a symlink out of it.
+// File system calls on a hanging share can't be cancelled. We stop waiting for them at the
+// deadline and don't start another probe of the same target and module until the hanging one returns.
+type prober struct {
+	fs     cifs.FS
+	config func() *config.Config
+
+	mutex   sync.Mutex
+	running map[string]bool
+}
+
+func newProber(fs cifs.FS, cfg func() *config.Config) *prober {
+	return &prober{fs: fs, config: cfg, running: map[string]bool{}}
+}
+
+// probe stores the state of a single probe. It is shared between the handler and the goroutine
+// doing the file system calls, which may still run after the deadline.
+type probe struct {
+	mutex     sync.Mutex
+	durations map[string]time.Duration
+	phase     string
+	start     time.Time
+}
+
+// run runs a phase of the probe and records its duration.
+func (p *probe) run(phase string, f func() error) error {
+	p.mutex.Lock()
+	p.phase, p.start = phase, time.Now()
+	p.mutex.Unlock()
+	err := f()
+	p.mutex.Lock()
+	p.durations[phase] = time.Since(p.start)
+	p.phase = ""
+	p.mutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("%s: %w", phase, err)
+	}
+	return nil
+}
+
+// snapshot returns the phase durations so far. The phase which is still running
+// gets the time it has been running.
+func (p *probe) snapshot() map[string]time.Duration {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	durations := map[string]time.Duration{}
+	for phase, d := range p.durations {
+		durations[phase] = d
+	}
+	if p.phase != "" {
+		durations[p.phase] = time.Since(p.start)
+	}
+	return durations
+}
+
+func (pr *prober) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "Target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	if !filepath.IsAbs(target) {
+		http.Error(w, fmt.Sprintf("Target %q is not an absolute path", target), http.StatusBadRequest)
+		return
+	}
+	target = filepath.Clean(target)
+	cfg := pr.config()
+	name := r.URL.Query().Get("module")
+	module, ok := cfg.Modules[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown module %q", name), http.StatusBadRequest)
+		return
+	}
+	mount := pr.mount(target)
+	if mount == nil && !module.AnyTarget {
+		http.Error(w, fmt.Sprintf("Target %q is not on a CIFS mount", target), http.StatusForbidden)
+		return
+	}
+	// The target is a path of the host, like the mount points
+	path := filepath.Join(cfg.Path.Rootfs, target)
+	root := path
+	if mount != nil && !module.AnyTarget {
+		root = filepath.Join(cfg.Path.Rootfs, mount.MountPoint)
+	}
+	timeout := module.Timeout
+	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
+		if seconds, err := strconv.ParseFloat(v, 64); err == nil {
+			if scrapeTimeout := time.Duration(seconds*float64(time.Second)) - scrapeTimeoutOffset; scrapeTimeout > 0 && scrapeTimeout < timeout {
+				timeout = scrapeTimeout
+			}
+		}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	registry := prometheus.NewRegistry()
+	// The probe metrics carry the same server and share labels as the share metrics
+	var server, share string
+	if mount != nil {
+		server, share = mount.Server, mount.Share
+	}
+	registerer := prometheus.WrapRegistererWith(prometheus.Labels{"server": server, "share": share}, registry)
+	success := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_success",
+		Help: "Boolean gauge of 1 if the probe succeeded, or 0 if not",
+	})
+	duration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_duration_seconds",
+		Help: "Duration of the probe in seconds",
+	})
+	phaseDuration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_phase_duration_seconds",
+		Help: "Duration of every phase of the probe in seconds, 0 if the phase did not run",
+	}, []string{"phase"})
+	errorClass := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_error",
+		Help: "Boolean gauge of 1 for the class of the error if the probe failed",
+	}, []string{"class"})
+	registerer.MustRegister(success, duration, phaseDuration, errorClass)
+
+	start := time.Now()
+	p, err := pr.probe(ctx, path, root, name, module)
+	duration.Set(time.Since(start).Seconds())
+	durations := p.snapshot()
+	for _, phase := range proberPhases[module.Prober] {
+		phaseDuration.WithLabelValues(phase).Set(durations[phase].Seconds())
+	}
+	class := ""
+	if err != nil {
+		class = classifyError(err)
+		log.Printf("Probe %s of %s failed: %v", name, target, err)
+	} else {
+		success.Set(1)
+	}
+	for _, c := range errorClasses {
+		errorClass.WithLabelValues(c).Set(boolToFloat(c == class))
+	}
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{ErrorLog: log.Default()}).ServeHTTP(w, r)
+}
+
+// probe runs the prober of the module against target until it finishes or ctx is done.
+// target must not lead out of root with a symlink.
+func (pr *prober) probe(ctx context.Context, target, root, name string, module config.Module) (*probe, error) {
+	p := &probe{durations: map[string]time.Duration{}}
+	key := name + "\x00" + target
+	pr.mutex.Lock()
+	if pr.running[key] {
+		pr.mutex.Unlock()
+		return p, fmt.Errorf("the previous probe still hangs: %w", context.DeadlineExceeded)
+	}
+	pr.running[key] = true
+	pr.mutex.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runProber(p, target, root, module)
+		pr.mutex.Lock()
+		delete(pr.running, key)
+		pr.mutex.Unlock()
+	}()
+	select {
+	case err := <-done:
+		return p, err
+	case <-ctx.Done():
+		return p, ctx.Err()
+	}
+}
+
+// runProber does the file system calls of the prober. We resolve the symlinks of the target and
+// of the directory of the probe file, the target must stay below root and the file below the target.
+// Nothing outside of root is touched, not even with a stat: a hanging share outside of it must not
+// block the prober. The probe file itself is never followed: the read prober opens it with O_NOFOLLOW
+// and the write prober creates a new file with a random name next to it and only deletes that file.
+func runProber(p *probe, target, root string, module config.Module) error {
+	err := p.run("stat", func() (err error) {
+		if root, err = filepath.EvalSymlinks(root); err != nil {
+			return err
+		}
+		if target, err = resolve(target, root); err != nil {
+			return err
+		}
+		// target has no symlinks anymore, so this does not leave root either
+		info, err := os.Stat(target)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return errNotADirectory
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// dir returns the directory of the probe file without symlinks
+	dir := func() (string, error) {
+		return resolve(filepath.Dir(filepath.Join(target, module.File)), target)
+	}
+	switch module.Prober {
+	case config.ProberList:
+		return p.run("list", func() error {
+			dir, err := os.Open(target)
+			if err != nil {
+				return err
+			}
+			defer dir.Close()
+			// A single batch is enough to see whether the server answers
+			if _, err := dir.Readdirnames(100); err != nil && err != io.EOF {
+				return err
+			}
+			return nil
+		})
+	case config.ProberRead:
+		var f *os.File
+		if err := p.run("open", func() error {
+			dir, err := dir()
+			if err != nil {
+				return err
+			}
+			f, err = os.OpenFile(filepath.Join(dir, filepath.Base(module.File)), os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+			return err
+		}); err != nil {
+			return err
+		}
+		err := p.run("read", func() error {
+			_, err := io.Copy(ioutil.Discard, io.LimitReader(f, module.Size))
+			return err
+		})
+		// The share may already be broken, but we must not leak the file
+		closeErr := p.run("close", f.Close)
+		if err != nil {
+			return err
+		}
+		return closeErr
+	case config.ProberWrite:
+		var f *os.File
+		if err := p.run("create", func() error {
+			dir, err := dir()
+			if err != nil {
+				return err
+			}
+			// CreateTemp uses O_EXCL, so it never opens an existing file or follows a symlink
+			f, err = os.CreateTemp(dir, filepath.Base(module.File)+"-*")
+			return err
+		}); err != nil {
+			return err
+		}
+		err := p.run("write", func() error {
+			_, err := f.Write(make([]byte, module.Size))
+			return err
+		})
+		if err == nil {
+			err = p.run("fsync", f.Sync)
+		}
+		// CIFS flushes cached writes on close, so close can fail as well
+		if closeErr := p.run("close", f.Close); err == nil {
+			err = closeErr
+		}
+		if removeErr := p.run("delete", func() error { return os.Remove(f.Name()) }); err == nil {
+			err = removeErr
+		}
+		return err
+	}
+	return nil
+}
+
+// below reports whether path is dir or a path below it.
+func below(path, dir string) bool {
+	return path == dir || dir == "/" || strings.HasPrefix(path, dir+"/")
+}
+
+// resolve resolves the symlinks of path, which has to be below root. root must not contain symlinks.
+// Unlike filepath.EvalSymlinks it refuses a symlink leading out of root before it looks at its target.
+func resolve(path, root string) (string, error) {
+	if !below(path, root) {
+		return "", errOutside
+	}
+	rest := strings.TrimPrefix(path, root)
+	resolved := root
+	links := 0
+	for rest != "" {
+		var name string
+		name, rest = rest, ""
+		if i := strings.IndexByte(name, '/'); i >= 0 {
+			name, rest = name[:i], name[i+1:]
+		}
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			if !below(resolved, root) {
+				return "", errOutside
+			}
+			continue
+		}
+		next := filepath.Join(resolved, name)
+		info, err := os.Lstat(next)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		// The same limit as in the kernel
+		if links++; links > 40 {
+			return "", &os.PathError{Op: "resolve", Path: path, Err: syscall.ELOOP}
+		}
+		link, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(resolved, link)
+		}
+		link = filepath.Clean(link)
+		if !below(link, root) {
+			return "", errOutside
+		}
+		// We go on with the rest of the link target, below root there are no symlinks left to resolve
+		rest = strings.TrimPrefix(link, root) + "/" + rest
+		resolved = root
+	}
+	return resolved, nil
+}
+
+// mount returns the CIFS mount target is on, the one with the longest mount point if they are
+// nested. It returns nil if target is not on a CIFS mount or we can't read the mounts.
+func (pr *prober) mount(target string) *cifs.Mount {
+	mounts, err := pr.fs.Mounts()
+	if err != nil {
+		return nil
+	}
+	var mount *cifs.Mount
+	for _, m := range mounts {
+		if !below(target, m.MountPoint) {
+			continue
+		}
+		if mount == nil || len(m.MountPoint) > len(mount.MountPoint) {
+			mount = m
+		}
+	}
+	return mount
+}
+
+// classifyError returns the error class of a probe error.
+func classifyError(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return errorClassTimeout
+	case errors.Is(err, errNotADirectory), errors.Is(err, syscall.ENOTDIR):
+		return errorClassNotADirectory
+	case errors.Is(err, os.ErrNotExist):
+		return errorClassNotFound
+	case errors.Is(err, os.ErrPermission):
+		return errorClassPermissionDenied
+	case errors.Is(err, syscall.EROFS):
+		return errorClassReadOnly
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT):
+		return errorClassNoSpace
+	case errors.Is(err, syscall.ESTALE):
+		return errorClassStale
+	case errors.Is(err, syscall.EHOSTDOWN), errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENOTCONN),
+		errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ENETUNREACH):
+		return errorClassHostDown
+	case errors.Is(err, syscall.EIO):
+		return errorClassIO
+	}
+	return errorClassOther
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
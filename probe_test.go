@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/shibumi/cifs-exporter/cifs"
+	"github.com/shibumi/cifs-exporter/config"
+)
+
+// probeModules are the modules of the tests, the any_ modules allow local directories.
+const probeModules = `
+modules:
+  stat:
+    prober: stat
+  any_stat:
+    prober: stat
+    any_target: true
+  any_list:
+    prober: list
+    any_target: true
+  any_read:
+    prober: read
+    file: canary.txt
+    timeout: 200ms
+    any_target: true
+  any_write:
+    prober: write
+    any_target: true
+  write:
+    prober: write
+  read:
+    prober: read
+    file: sub/canary.txt
+`
+
+// newTestProber returns a prober with the test modules. The proc directory lists a CIFS mount
+// of \\srv\data at mountPoint, if it is not empty.
+func newTestProber(t *testing.T, mountPoint string) *prober {
+	t.Helper()
+	proc := t.TempDir()
+	if err := os.Mkdir(filepath.Join(proc, "self"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	mountinfo := "23 28 0:22 / /proc rw,nosuid shared:12 - proc proc rw\n"
+	if mountPoint != "" {
+		mountinfo += "36 29 0:45 / " + mountPoint + " rw,relatime shared:101 - cifs //srv/data rw,vers=3.1.1\n"
+	}
+	writeFile(t, filepath.Join(proc, "self", "mountinfo"), mountinfo)
+	fs, err := cifs.NewFS(proc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(probeModules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newProber(fs, func() *config.Config { return cfg })
+}
+
+// runProbe probes target with module and returns the status and the body of the response.
+func runProbe(pr *prober, target, module string) (int, string) {
+	req := httptest.NewRequest(http.MethodGet, "/probe?target="+target+"&module="+module, nil)
+	rec := httptest.NewRecorder()
+	pr.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.String()
+}
+
+// checkProbe checks that the probe succeeded or failed with the error class.
+func checkProbe(t *testing.T, body string, class string) {
+	t.Helper()
+	success := `probe_success{server="",share=""} 1`
+	if class != "" {
+		success = `probe_success{server="",share=""} 0`
+	}
+	if !strings.Contains(body, success) {
+		t.Errorf("%s missing in\n%s", success, body)
+	}
+	for _, c := range errorClasses {
+		want := fmt.Sprintf(`probe_error{class="%s",server="",share=""} %d`, c, map[bool]int{true: 1}[c == class])
+		if !strings.Contains(body, want) {
+			t.Errorf("%s missing in\n%s", want, body)
+		}
+	}
+}
+
+func TestProbers(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "canary.txt"), "hello")
+	file := filepath.Join(dir, "file")
+	writeFile(t, file, "")
+	pr := newTestProber(t, "")
+
+	tests := []struct {
+		module, target string
+		class          string
+		phases         []string
+	}{
+		{"any_stat", dir, "", []string{"stat"}},
+		{"any_list", dir, "", []string{"stat", "list"}},
+		{"any_read", dir, "", []string{"stat", "open", "read", "close"}},
+		{"any_write", dir, "", []string{"stat", "create", "write", "fsync", "close", "delete"}},
+		{"any_stat", filepath.Join(dir, "missing"), errorClassNotFound, nil},
+		{"any_stat", file, errorClassNotADirectory, nil},
+		{"any_read", filepath.Join(dir, "empty"), errorClassNotFound, nil},
+	}
+	if err := os.Mkdir(filepath.Join(dir, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		t.Run(test.module+" "+filepath.Base(test.target), func(t *testing.T) {
+			status, body := runProbe(pr, test.target, test.module)
+			if status != http.StatusOK {
+				t.Fatalf("status = %d: %s", status, body)
+			}
+			checkProbe(t, body, test.class)
+			for _, phase := range test.phases {
+				if !strings.Contains(body, `probe_phase_duration_seconds{phase="`+phase+`",server="",share=""}`) {
+					t.Errorf("phase %s missing in\n%s", phase, body)
+				}
+			}
+		})
+	}
+
+	// The write prober cleans up after itself
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), config.DefaultProbeFile) {
+			t.Errorf("probe file %s left behind", f.Name())
+		}
+	}
+}
+
+func TestProbeRequests(t *testing.T) {
+	dir := t.TempDir()
+	pr := newTestProber(t, "")
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{"module=any_stat", http.StatusBadRequest},
+		{"target=relative&module=any_stat", http.StatusBadRequest},
+		{"target=" + dir, http.StatusBadRequest},
+		{"target=" + dir + "&module=unknown", http.StatusBadRequest},
+		// Without any_target only CIFS mounts may be probed
+		{"target=" + dir + "&module=stat", http.StatusForbidden},
+		{"target=/etc&module=write", http.StatusForbidden},
+		{"target=" + dir + "&module=any_stat", http.StatusOK},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/probe?"+test.query, nil)
+		rec := httptest.NewRecorder()
+		pr.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s: status = %d, want %d", test.query, rec.Code, test.status)
+		}
+	}
+}
+
+func TestProbeCIFSMount(t *testing.T) {
+	mount := t.TempDir()
+	if err := os.Mkdir(filepath.Join(mount, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	pr := newTestProber(t, mount)
+	for _, target := range []string{mount, filepath.Join(mount, "sub")} {
+		status, body := runProbe(pr, target, "write")
+		if status != http.StatusOK {
+			t.Fatalf("status = %d: %s", status, body)
+		}
+		if want := `probe_success{server="srv",share="data"} 1`; !strings.Contains(body, want) {
+			t.Errorf("%s missing in\n%s", want, body)
+		}
+	}
+	// The mount point is a directory, not a prefix of the path
+	if status, _ := runProbe(pr, mount+"-other", "stat"); status != http.StatusForbidden {
+		t.Errorf("status = %d for a sibling of the mount, want %d", status, http.StatusForbidden)
+	}
+}
+
+func TestProbeSymlinks(t *testing.T) {
+	mount := t.TempDir()
+	outside := t.TempDir()
+	victim := filepath.Join(outside, "victim")
+	writeFile(t, victim, "precious")
+	for _, link := range []struct{ old, new string }{
+		{outside, filepath.Join(mount, "escape")},
+		{outside, filepath.Join(mount, "sub")},
+		{victim, filepath.Join(mount, config.DefaultProbeFile)},
+		// stat would report not_found if it followed the link
+		{filepath.Join(outside, "missing"), filepath.Join(mount, "missing")},
+	} {
+		if err := os.Symlink(link.old, link.new); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pr := newTestProber(t, mount)
+
+	// A planted probe file is neither overwritten nor deleted
+	status, body := runProbe(pr, mount, "write")
+	if status != http.StatusOK || !strings.Contains(body, `probe_success{server="srv",share="data"} 1`) {
+		t.Errorf("write probe failed: %d %s", status, body)
+	}
+	if content, err := ioutil.ReadFile(victim); err != nil || string(content) != "precious" {
+		t.Errorf("victim = %q, %v", content, err)
+	}
+	if _, err := os.Lstat(filepath.Join(mount, config.DefaultProbeFile)); err != nil {
+		t.Errorf("planted symlink removed: %v", err)
+	}
+
+	// A target or a probe file directory leading out of the mount is refused
+	for _, test := range []struct{ target, module string }{
+		{filepath.Join(mount, "escape"), "write"},
+		{filepath.Join(mount, "missing"), "stat"},
+		{mount, "read"},
+	} {
+		status, body := runProbe(pr, test.target, test.module)
+		if status != http.StatusOK || !strings.Contains(body, `probe_error{class="permission_denied",server="srv",share="data"} 1`) {
+			t.Errorf("probe %s of %s did not fail with permission_denied: %d %s", test.module, test.target, status, body)
+		}
+	}
+	files, err := ioutil.ReadDir(outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("probe wrote to %s: %v", outside, files)
+	}
+
+	// The read prober does not follow a symlink at the probe file
+	local := t.TempDir()
+	if err := os.Symlink(victim, filepath.Join(local, "canary.txt")); err != nil {
+		t.Fatal(err)
+	}
+	_, body = runProbe(pr, local, "any_read")
+	if strings.Contains(body, `probe_success{server="",share=""} 1`) {
+		t.Errorf("read probe followed the symlink:\n%s", body)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "a", "b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range []struct{ old, new string }{
+		{"a/b", filepath.Join(root, "rel")},
+		{filepath.Join(root, "a"), filepath.Join(root, "abs")},
+		{"../..", filepath.Join(root, "a", "b", "up")},
+		{"..", filepath.Join(root, "a", "b", "parent")},
+		{outside, filepath.Join(root, "out")},
+		{"loop", filepath.Join(root, "loop")},
+	} {
+		if err := os.Symlink(link.old, link.new); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		path string
+		want string
+		err  error
+	}{
+		{root, root, nil},
+		{filepath.Join(root, "a", "b"), filepath.Join(root, "a", "b"), nil},
+		{filepath.Join(root, "rel"), filepath.Join(root, "a", "b"), nil},
+		{filepath.Join(root, "abs", "b"), filepath.Join(root, "a", "b"), nil},
+		{filepath.Join(root, "a", "b", "parent"), filepath.Join(root, "a"), nil},
+		{filepath.Join(root, "a", "b", "up"), root, nil},
+		{filepath.Join(root, "a", "b", "up", "out", "x"), "", errOutside},
+		{filepath.Join(root, "out"), "", errOutside},
+		{root + "/a/../..", "", errOutside},
+		{filepath.Join(root, "missing"), "", os.ErrNotExist},
+		{filepath.Join(root, "loop"), "", syscall.ELOOP},
+		{outside, "", errOutside},
+	}
+	for _, test := range tests {
+		got, err := resolve(test.path, root)
+		if got != test.want || !errors.Is(err, test.err) {
+			t.Errorf("resolve(%s) = %q, %v, want %q, %v", test.path, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestProbeTimeout(t *testing.T) {
+	dir := t.TempDir()
+	// Opening a FIFO for reading hangs until somebody opens it for writing
+	fifo := filepath.Join(dir, "canary.txt")
+	if err := syscall.Mkfifo(fifo, 0600); err != nil {
+		t.Skipf("can't create FIFO: %v", err)
+	}
+	pr := newTestProber(t, "")
+
+	start := time.Now()
+	_, body := runProbe(pr, dir, "any_read")
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("probe took %s, the module timeout is 200ms", d)
+	}
+	checkProbe(t, body, errorClassTimeout)
+	// The phase which hangs reports the time it has been running
+	if strings.Contains(body, `probe_phase_duration_seconds{phase="open",server="",share=""} 0`+"\n") {
+		t.Errorf("open phase has no duration:\n%s", body)
+	}
+
+	// The hanging call blocks further probes of the same target and module
+	start = time.Now()
+	_, body = runProbe(pr, dir, "any_read")
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("second probe waited %s", d)
+	}
+	checkProbe(t, body, errorClassTimeout)
+
+	// Release the hanging open, afterwards the target can be probed again
+	w, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pr.mutex.Lock()
+		running := len(pr.running)
+		pr.mutex.Unlock()
+		if running == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("probe still running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err   error
+		class string
+	}{
+		{context.DeadlineExceeded, errorClassTimeout},
+		{fmt.Errorf("open: %w", context.DeadlineExceeded), errorClassTimeout},
+		{errNotADirectory, errorClassNotADirectory},
+		{&os.PathError{Op: "open", Path: "/x", Err: syscall.ENOTDIR}, errorClassNotADirectory},
+		{&os.PathError{Op: "stat", Path: "/x", Err: syscall.ENOENT}, errorClassNotFound},
+		{&os.PathError{Op: "open", Path: "/x", Err: syscall.EACCES}, errorClassPermissionDenied},
+		{&os.PathError{Op: "open", Path: "/x", Err: syscall.EPERM}, errorClassPermissionDenied},
+		{errOutside, errorClassPermissionDenied},
+		{&os.PathError{Op: "open", Path: "/x", Err: syscall.EROFS}, errorClassReadOnly},
+		{&os.PathError{Op: "write", Path: "/x", Err: syscall.ENOSPC}, errorClassNoSpace},
+		{&os.PathError{Op: "write", Path: "/x", Err: syscall.EDQUOT}, errorClassNoSpace},
+		{&os.PathError{Op: "stat", Path: "/x", Err: syscall.ESTALE}, errorClassStale},
+		{&os.PathError{Op: "stat", Path: "/x", Err: syscall.EHOSTDOWN}, errorClassHostDown},
+		{&os.PathError{Op: "stat", Path: "/x", Err: syscall.ECONNRESET}, errorClassHostDown},
+		{&os.PathError{Op: "read", Path: "/x", Err: syscall.EIO}, errorClassIO},
+		{errors.New("something else"), errorClassOther},
+	}
+	for _, test := range tests {
+		if got := classifyError(test.err); got != test.class {
+			t.Errorf("classifyError(%v) = %s, want %s", test.err, got, test.class)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shibumi/cifs-exporter/collector"
+	"github.com/shibumi/cifs-exporter/config"
+)
+
+// reloader reloads the configuration on SIGHUP and, with -web.enable-lifecycle, on POST /-/reload.
+// The collector settings are swapped on the running collector, so we don't lose its state.
+// The web settings and the procfs root are only read at startup.
+type reloader struct {
+	load      func() (*config.Config, error)
+	collector *collector.CIFSCollector
+
+	mutex   sync.Mutex
+	current *config.Config
+
+	success     prometheus.Gauge
+	successTime prometheus.Gauge
+}
+
+func newReloader(cfg *config.Config, load func() (*config.Config, error), c *collector.CIFSCollector) *reloader {
+	r := &reloader{
+		load:      load,
+		collector: c,
+		current:   cfg,
+		success: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "cifs_exporter_config_last_reload_successful",
+			Help: "Whether the last configuration reload attempt was successful",
+		}),
+		successTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "cifs_exporter_config_last_reload_success_timestamp_seconds",
+			Help: "Unix timestamp of the last successful configuration reload",
+		}),
+	}
+	r.success.Set(1)
+	r.successTime.SetToCurrentTime()
+	return r
+}
+
+// reload reads the configuration again. If it is invalid, we keep the old one.
+func (r *reloader) reload() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	cfg, err := r.load()
+	if err != nil {
+		r.success.Set(0)
+		return err
+	}
+	if !reflect.DeepEqual(cfg.Web, r.current.Web) || cfg.Path.Procfs != r.current.Path.Procfs {
+		log.Printf("The web settings or the procfs root changed, they are only applied after a restart")
+	}
+	r.collector.SetConfig(cfg.CollectorConfig())
+	r.current = cfg
+	r.success.Set(1)
+	r.successTime.SetToCurrentTime()
+	return nil
+}
+
+// config returns the current configuration.
+func (r *reloader) config() *config.Config {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.current
+}
+
+// watchSignals reloads the configuration on every SIGHUP.
+func (r *reloader) watchSignals() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := r.reload(); err != nil {
+			log.Printf("Could not reload configuration: %v", err)
+			continue
+		}
+		log.Printf("Reloaded configuration")
+	}
+}
+
+// handle reloads the configuration on POST /-/reload.
+func (r *reloader) handle(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// The error may show paths and contents of the configuration, so it only goes to the log
+	if err := r.reload(); err != nil {
+		log.Printf("Could not reload configuration: %v", err)
+		http.Error(w, "Could not reload configuration, see the log of the exporter", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Reloaded configuration")
+}
+
+// lifecycleDisabled answers POST /-/reload without -web.enable-lifecycle.
+func lifecycleDisabled(w http.ResponseWriter, req *http.Request) {
+	http.Error(w, "Lifecycle API is not enabled", http.StatusForbidden)
+}
+
+// Describe and Collect export the reload metrics.
+func (r *reloader) Describe(ch chan<- *prometheus.Desc) {
+	r.success.Describe(ch)
+	r.successTime.Describe(ch)
+}
+
+func (r *reloader) Collect(ch chan<- prometheus.Metric) {
+	r.success.Collect(ch)
+	r.successTime.Collect(ch)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shibumi/cifs-exporter/cifs"
+	"github.com/shibumi/cifs-exporter/collector"
+)
+
+// newTestReloader returns a reloader for the configuration file name, loaded like on the command line.
+func newTestReloader(t *testing.T, name string) *reloader {
+	t.Helper()
+	f := parseFlags(t, "-config.file", name)
+	cfg, err := f.loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := cifs.NewFS(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newReloader(cfg, f.loadConfig, collector.NewCIFSCollector(fs, cfg.CollectorConfig()))
+	// An old timestamp, so we see whether a reload sets it
+	r.successTime.Set(1)
+	return r
+}
+
+// postReload sends a request to the reload handler and returns the status and body.
+func postReload(t *testing.T, handler http.HandlerFunc, method string) (int, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(method, "/-/reload", nil))
+	body, err := ioutil.ReadAll(w.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, string(body)
+}
+
+func TestReload(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "config.yml")
+	writeFile(t, name, "metrics:\n  legacy_names: false\n")
+	r := newTestReloader(t, name)
+
+	writeFile(t, name, "metrics:\n  legacy_names: true\n")
+	if code, body := postReload(t, r.handle, http.MethodPost); code != http.StatusOK {
+		t.Fatalf("status %d: %s", code, body)
+	}
+	if !r.config().Metrics.LegacyNames {
+		t.Error("reload did not apply the new configuration")
+	}
+	if v := testutil.ToFloat64(r.success); v != 1 {
+		t.Errorf("cifs_exporter_config_last_reload_successful %v, want 1", v)
+	}
+	if v := testutil.ToFloat64(r.successTime); v <= 1 {
+		t.Errorf("cifs_exporter_config_last_reload_success_timestamp_seconds %v was not updated", v)
+	}
+}
+
+func TestReloadInvalid(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "config.yml")
+	writeFile(t, name, "metrics:\n  legacy_names: true\n")
+	r := newTestReloader(t, name)
+	old := r.config()
+
+	writeFile(t, name, "metrics:\n  legacy_names: true\n  secret_field: hunter2\n")
+	code, body := postReload(t, r.handle, http.MethodPost)
+	if code != http.StatusInternalServerError {
+		t.Errorf("status %d, want %d", code, http.StatusInternalServerError)
+	}
+	// The response must not show the file or the error
+	if strings.Contains(body, "secret_field") || strings.Contains(body, name) {
+		t.Errorf("response shows the error: %s", body)
+	}
+	if r.config() != old {
+		t.Error("invalid configuration replaced the old one")
+	}
+	if v := testutil.ToFloat64(r.success); v != 0 {
+		t.Errorf("cifs_exporter_config_last_reload_successful %v, want 0", v)
+	}
+	if v := testutil.ToFloat64(r.successTime); v != 1 {
+		t.Errorf("cifs_exporter_config_last_reload_success_timestamp_seconds %v, want 1", v)
+	}
+
+	// The next valid file is applied again
+	writeFile(t, name, "metrics:\n  legacy_names: false\n")
+	if code, body := postReload(t, r.handle, http.MethodPost); code != http.StatusOK {
+		t.Fatalf("status %d: %s", code, body)
+	}
+	if r.config().Metrics.LegacyNames {
+		t.Error("reload did not apply the new configuration")
+	}
+	if v := testutil.ToFloat64(r.success); v != 1 {
+		t.Errorf("cifs_exporter_config_last_reload_successful %v, want 1", v)
+	}
+}
+
+func TestReloadMethod(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "config.yml")
+	writeFile(t, name, "")
+	r := newTestReloader(t, name)
+	if code, _ := postReload(t, r.handle, http.MethodGet); code != http.StatusMethodNotAllowed {
+		t.Errorf("status %d, want %d", code, http.StatusMethodNotAllowed)
+	}
+	if v := testutil.ToFloat64(r.successTime); v != 1 {
+		t.Errorf("GET reloaded the configuration")
+	}
+}
+
+func TestReloadLifecycleDisabled(t *testing.T) {
+	if code, _ := postReload(t, lifecycleDisabled, http.MethodPost); code != http.StatusForbidden {
+		t.Errorf("status %d, want %d", code, http.StatusForbidden)
+	}
+	cfg, err := parseFlags(t).loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Web.EnableLifecycle {
+		t.Error("lifecycle API is enabled by default")
+	}
+	cfg, err = parseFlags(t, "-web.enable-lifecycle").loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Web.EnableLifecycle {
+		t.Error("-web.enable-lifecycle did not enable the lifecycle API")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/prometheus/exporter-toolkit/web"
+	"github.com/shibumi/cifs-exporter/config"
+)
+
+// newServer returns a HTTP server with the timeouts of the configuration.
+func newServer(cfg config.WebConfig) *http.Server {
+	return &http.Server{
+		ReadHeaderTimeout: cfg.ReadTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+}
+
+// serve serves the default mux on every listener until one of them fails or we receive SIGINT
+// or SIGTERM. On a signal we stop accepting new connections and wait for the active requests
+// until the shutdown timeout is over. Requests still running then are cut off, which is no error.
+func serve(listeners []net.Listener, cfg config.WebConfig, logger kitlog.Logger) error {
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGINT, syscall.SIGTERM)
+	return serveUntil(listeners, cfg, logger, term)
+}
+
+// serveUntil works like serve, but shuts down on the first value of stop instead of a signal.
+func serveUntil(listeners []net.Listener, cfg config.WebConfig, logger kitlog.Logger, stop <-chan os.Signal) error {
+	errs := make(chan error, len(listeners))
+	var servers []*http.Server
+	for _, l := range listeners {
+		// Every listener gets its own server, web.Serve wraps the handler of the server
+		srv := newServer(cfg)
+		servers = append(servers, srv)
+		go func(l net.Listener) {
+			errs <- web.Serve(l, srv, cfg.ConfigFile, logger)
+		}(l)
+	}
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-stop:
+		log.Printf("Received %s, shutting down", sig)
+	}
+	ctx := context.Background()
+	if cfg.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.ShutdownTimeout)
+		defer cancel()
+	}
+	var wg sync.WaitGroup
+	shutdownErrs := make(chan error, len(servers))
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			shutdownErrs <- srv.Shutdown(ctx)
+		}(srv)
+	}
+	wg.Wait()
+	close(shutdownErrs)
+	for err := range shutdownErrs {
+		if errors.Is(err, context.DeadlineExceeded) {
+			log.Printf("Shutdown timeout of %s exceeded, closing the active connections", cfg.ShutdownTimeout)
+			for _, srv := range servers {
+				srv.Close()
+			}
+			return nil
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+	}
+	return nil
+}
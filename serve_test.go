@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/shibumi/cifs-exporter/config"
+)
+
+// secretHash is the bcrypt hash of "secret".
+const secretHash = "$2a$10$8hv9xchZqYslCuMbrLCI9O8dx/ryvs0EtgjJGdf/77k5OMker0zuy"
+
+// slowStarted and slowRelease control the /test/slow handler, which hangs until it is released
+// or the server closes the connection.
+var slowStarted, slowRelease = make(chan struct{}, 1), make(chan struct{})
+
+func init() {
+	http.HandleFunc("/test", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	http.HandleFunc("/test/slow", func(w http.ResponseWriter, r *http.Request) {
+		slowStarted <- struct{}{}
+		select {
+		case <-slowRelease:
+			w.Write([]byte("ok"))
+		case <-r.Context().Done():
+		}
+	})
+}
+
+// testCert is a certificate with its key, the PEM files are written to the test directory.
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCert creates a certificate for 127.0.0.1 in dir. It is self-signed if parent is nil.
+func newTestCert(t *testing.T, dir, name string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{usage},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testCert{cert: cert, key: key, certFile: filepath.Join(dir, name+".crt"), keyFile: filepath.Join(dir, name+".key")}
+	writeFile(t, c.certFile, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	writeFile(t, c.keyFile, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})))
+	return c
+}
+
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startServer serves on a random port with the web configuration and returns its address.
+// The server is shut down at the end of the test.
+func startServer(t *testing.T, webConfig string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.WebConfig{ConfigFile: webConfig, ReadTimeout: 5 * time.Second, WriteTimeout: 5 * time.Second, ShutdownTimeout: time.Second}
+	stop := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- serveUntil([]net.Listener{l}, cfg, kitlog.NewNopLogger(), stop)
+	}()
+	t.Cleanup(func() {
+		stop <- syscall.SIGTERM
+		if err := <-done; err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	})
+	return l.Addr().String()
+}
+
+// tlsClient returns a HTTPS client which trusts ca and presents cert, if it is not nil.
+// The client sends cert even if the server does not accept its issuer.
+func tlsClient(ca *testCert, cert *testCert) *http.Client {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	tlsConfig := &tls.Config{RootCAs: pool}
+	if cert != nil {
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &tls.Certificate{Certificate: [][]byte{cert.cert.Raw}, PrivateKey: cert.key}, nil
+		}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}, Timeout: 5 * time.Second}
+}
+
+func TestServeBasicAuth(t *testing.T) {
+	dir := t.TempDir()
+	server := newTestCert(t, dir, "server", nil, x509.ExtKeyUsageServerAuth)
+	webConfig := filepath.Join(dir, "web.yml")
+	writeFile(t, webConfig, `tls_server_config:
+  cert_file: `+server.certFile+`
+  key_file: `+server.keyFile+`
+basic_auth_users:
+  prometheus: `+secretHash+`
+`)
+	addr := startServer(t, webConfig)
+	c := tlsClient(server, nil)
+
+	tests := []struct {
+		name           string
+		user, password string
+		status         int
+	}{
+		{"no credentials", "", "", http.StatusUnauthorized},
+		{"wrong password", "prometheus", "wrong", http.StatusUnauthorized},
+		{"unknown user", "admin", "secret", http.StatusUnauthorized},
+		{"valid credentials", "prometheus", "secret", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "https://"+addr+"/test", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.user != "" {
+				req.SetBasicAuth(test.user, test.password)
+			}
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, test.status)
+			}
+		})
+	}
+
+	// Plain HTTP must not work on a TLS listener
+	resp, err := http.Get("http://" + addr + "/test")
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Error("plain HTTP request succeeded")
+		}
+	}
+}
+
+func TestServeClientCert(t *testing.T) {
+	dir := t.TempDir()
+	server := newTestCert(t, dir, "server", nil, x509.ExtKeyUsageServerAuth)
+	ca := newTestCert(t, dir, "ca", nil, x509.ExtKeyUsageClientAuth)
+	webConfig := filepath.Join(dir, "web.yml")
+	writeFile(t, webConfig, `tls_server_config:
+  cert_file: `+server.certFile+`
+  key_file: `+server.keyFile+`
+  client_auth_type: RequireAndVerifyClientCert
+  client_ca_file: `+ca.certFile+`
+`)
+	addr := startServer(t, webConfig)
+
+	tests := []struct {
+		name string
+		cert *testCert
+		ok   bool
+	}{
+		{"no client cert", nil, false},
+		{"unknown client cert", newTestCert(t, dir, "other", nil, x509.ExtKeyUsageClientAuth), false},
+		{"client cert of the CA", newTestCert(t, dir, "client", ca, x509.ExtKeyUsageClientAuth), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := tlsClient(server, test.cert).Get("https://" + addr + "/test")
+			if !test.ok {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("request succeeded with status %d", resp.StatusCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+		})
+	}
+}
+
+// startSlowRequest serves on a random port until stop and starts a request to /test/slow.
+// It returns once the handler runs.
+func startSlowRequest(t *testing.T, shutdownTimeout time.Duration, stop <-chan os.Signal) (addr string, done <-chan error, response <-chan *http.Response) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.WebConfig{ReadTimeout: 5 * time.Second, WriteTimeout: 5 * time.Second, ShutdownTimeout: shutdownTimeout}
+	served := make(chan error, 1)
+	go func() {
+		served <- serveUntil([]net.Listener{l}, cfg, kitlog.NewNopLogger(), stop)
+	}()
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := (&http.Client{Timeout: 10 * time.Second}).Get("http://" + l.Addr().String() + "/test/slow")
+		if err != nil {
+			resp = nil
+		}
+		responses <- resp
+	}()
+	select {
+	case <-slowStarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request did not start")
+	}
+	return l.Addr().String(), served, responses
+}
+
+func TestServeDrainsOnShutdown(t *testing.T) {
+	stop := make(chan os.Signal, 1)
+	addr, done, response := startSlowRequest(t, 10*time.Second, stop)
+	stop <- syscall.SIGTERM
+
+	// New connections are refused while the active request runs
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("listener is still open after the shutdown started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("serve returned before the active request finished: %v", err)
+	default:
+	}
+
+	slowRelease <- struct{}{}
+	resp := <-response
+	if resp == nil {
+		t.Fatal("active request failed during the shutdown")
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status %d, want 200", resp.StatusCode)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("serve: %v", err)
+	}
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	stop := make(chan os.Signal, 1)
+	_, done, response := startSlowRequest(t, 100*time.Millisecond, stop)
+	stop <- syscall.SIGTERM
+	select {
+	case err := <-done:
+		// Cutting off the request after the timeout is no error, so we exit with 0
+		if err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not return after the shutdown timeout")
+	}
+	if resp := <-response; resp != nil {
+		resp.Body.Close()
+		t.Errorf("request was not cut off, status %d", resp.StatusCode)
+	}
+}